@@ -0,0 +1,82 @@
+package conftagz
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// AUTOENVTAG in an env: tag derives the env var name from the field's path,
+// i.e. env:"auto" on SSL.Cert is SSL_CERT
+const AUTOENVTAG = "auto"
+
+// Case of automatic env var names. See EnvFieldSubstOpts.AutoEnvCase
+const (
+	AUTOENVUPPER int = iota
+	AUTOENVLOWER
+	AUTOENVASIS
+)
+
+// autoEnvName derives the env var name for the field at path, i.e. "SSL.Cert" or
+// "Backends[primary].Port", from the field names or from the yaml keys
+func autoEnvName(opts *EnvFieldSubstOpts, root reflect.Type, path string) string {
+	if opts.AutoEnvUseKeys && root != nil {
+		path = keyPath(root, path)
+	}
+	sep := opts.AutoEnvSeparator
+	if len(sep) < 1 {
+		sep = "_"
+	}
+	// map keys and slice indexes are parts of the name, i.e. BACKENDS_PRIMARY_PORT
+	path = strings.ReplaceAll(path, "]", "")
+	path = strings.ReplaceAll(path, "[", ".")
+	parts := strings.Split(path, ".")
+	if len(opts.Prefix) > 0 {
+		parts = append([]string{strings.TrimSuffix(opts.Prefix, sep)}, parts...)
+	}
+	name := strings.Join(parts, sep)
+	switch opts.AutoEnvCase {
+	case AUTOENVLOWER:
+		return strings.ToLower(name)
+	case AUTOENVASIS:
+		return name
+	}
+	return strings.ToUpper(name)
+}
+
+// envTagName returns the env var name for a field with the env: tag value tag.
+// An empty string means the field has no env var.
+func envTagName(opts *EnvFieldSubstOpts, root reflect.Type, path string, field reflect.StructField, tag string) string {
+	if tag == "-" {
+		return ""
+	}
+	if tag == AUTOENVTAG || (len(tag) < 1 && opts.AutoEnv) {
+		// structs are not named, their fields are
+		if !opts.converters.orDefault().isLeafType(field.Type) {
+			return ""
+		}
+		return autoEnvName(opts, root, path)
+	}
+	return tag
+}
+
+// envNameTracker finds two fields using the same env var when at least one of the
+// names was made automatically, which is almost certainly a mistake
+type envNameTracker struct {
+	// env var name to the path of the field using it, and if the name was automatic
+	paths map[string]string
+	auto  map[string]bool
+}
+
+func newEnvNameTracker() *envNameTracker {
+	return &envNameTracker{paths: make(map[string]string), auto: make(map[string]bool)}
+}
+
+func (t *envNameTracker) add(name string, path string, auto bool) error {
+	if other, ok := t.paths[name]; ok && other != path && (auto || t.auto[name]) {
+		return fmt.Errorf("env %s is used by both %s and %s", name, other, path)
+	}
+	t.paths[name] = path
+	t.auto[name] = t.auto[name] || auto
+	return nil
+}
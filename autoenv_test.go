@@ -0,0 +1,95 @@
+package conftagz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type AutoEnvSSL struct {
+	Cert string `yaml:"cert" env:"auto"`
+	Key  string `yaml:"key"`
+}
+
+type AutoEnvBackend struct {
+	Port int `yaml:"port"`
+}
+
+type AutoEnvStruct struct {
+	Name     string                     `yaml:"name"`
+	Secret   string                     `yaml:"secret" env:"-"`
+	Explicit string                     `yaml:"explicit" env:"EXPLICIT_NAME"`
+	SSL      *AutoEnvSSL                `yaml:"sslstuff"`
+	Backends map[string]*AutoEnvBackend `yaml:"backends"`
+}
+
+func TestAutoEnvTag(t *testing.T) {
+	var s AutoEnvStruct
+	m := map[string]string{"APP_SSL_CERT": "cert.pem", "APP_SSL_KEY": "key.pem", "APP_NAME": "x"}
+	touched, err := EnvFieldSubstitutionFromMap(&s, &EnvFieldSubstOpts{Prefix: "APP"}, m)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"SSL.Cert"}, touched)
+	assert.Equal(t, "cert.pem", s.SSL.Cert)
+	assert.Equal(t, "", s.SSL.Key)
+	assert.Equal(t, "", s.Name)
+}
+
+func TestAutoEnvMode(t *testing.T) {
+	s := AutoEnvStruct{Backends: map[string]*AutoEnvBackend{"primary": {}}}
+	m := map[string]string{
+		"APP_SSLSTUFF_CERT":         "cert.pem",
+		"APP_SSLSTUFF_KEY":          "key.pem",
+		"APP_NAME":                  "x",
+		"APP_SECRET":                "leaked",
+		"EXPLICIT_NAME":             "explicit",
+		"APP_BACKENDS_PRIMARY_PORT": "8080",
+	}
+	_, err := EnvFieldSubstitutionFromMap(&s, &EnvFieldSubstOpts{Prefix: "APP_", AutoEnv: true, AutoEnvUseKeys: true}, m)
+	assert.Nil(t, err)
+	assert.Equal(t, "cert.pem", s.SSL.Cert)
+	assert.Equal(t, "key.pem", s.SSL.Key)
+	assert.Equal(t, "x", s.Name)
+	assert.Equal(t, "", s.Secret)
+	assert.Equal(t, "explicit", s.Explicit)
+	assert.Equal(t, 8080, s.Backends["primary"].Port)
+}
+
+func TestAutoEnvCase(t *testing.T) {
+	var s AutoEnvStruct
+	m := map[string]string{"app.SSL.Cert": "cert.pem", "app-ssl-key": "key.pem"}
+	_, err := EnvFieldSubstitutionFromMap(&s, &EnvFieldSubstOpts{Prefix: "app", AutoEnvSeparator: ".", AutoEnvCase: AUTOENVASIS}, m)
+	assert.Nil(t, err)
+	assert.Equal(t, "cert.pem", s.SSL.Cert)
+
+	s = AutoEnvStruct{}
+	_, err = EnvFieldSubstitutionFromMap(&s, &EnvFieldSubstOpts{Prefix: "app", AutoEnv: true, AutoEnvSeparator: "-", AutoEnvCase: AUTOENVLOWER}, m)
+	assert.Nil(t, err)
+	assert.Equal(t, "key.pem", s.SSL.Key)
+}
+
+type AutoEnvCollideStruct struct {
+	DBHost string `env:"APP_DB_HOST"`
+	DB     struct {
+		Host string
+	}
+}
+
+func TestAutoEnvCollision(t *testing.T) {
+	var s AutoEnvCollideStruct
+	_, err := EnvFieldSubstitutionFromMap(&s, &EnvFieldSubstOpts{Prefix: "APP", AutoEnv: true}, nil)
+	assert.EqualError(t, err, "field DB.Host: env APP_DB_HOST is used by both DBHost and DB.Host")
+}
+
+func TestAutoEnvProvenance(t *testing.T) {
+	t.Setenv("APP_SSL_CERT", "cert.pem")
+	var s AutoEnvStruct
+	prov := Provenance{}
+	err := NewProcessor(nil).Process(&ConfTagOpts{
+		OrderOfOps: []int{ENVTAGS},
+		EnvOpts:    &EnvFieldSubstOpts{Prefix: "APP"},
+		Provenance: prov,
+	}, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, STAGEENV, prov["SSL.Cert"].Stage)
+	assert.Equal(t, "APP_SSL_CERT", prov["SSL.Cert"].Key)
+}
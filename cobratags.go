@@ -40,6 +40,38 @@ type cobraFlagSetRetriever struct {
 	varbool bool
 	varint  int64
 	varuint uint64
+	varconv *convertedFlagValue
+	varlist listFlagValue
+}
+
+// convertedFlagValue is a pflag.Value for the types parsed by convertString, i.e. time.Duration
+type convertedFlagValue struct {
+	conv   converterMap
+	t      reflect.Type
+	layout string
+	val    reflect.Value
+	set    bool
+}
+
+func (v *convertedFlagValue) String() string {
+	if !v.set {
+		return ""
+	}
+	return fmt.Sprint(v.val.Interface())
+}
+
+func (v *convertedFlagValue) Set(s string) (err error) {
+	val, err := v.conv.convertString(v.t, s, v.layout)
+	if err != nil {
+		return
+	}
+	v.val = val
+	v.set = true
+	return
+}
+
+func (v *convertedFlagValue) Type() string {
+	return strings.ToLower(v.t.Name())
 }
 
 type ProcessedCobraTags struct {
@@ -69,16 +101,27 @@ type CobraFieldSubstOpts struct {
 	Tags *ProcessedCobraTags
 }
 
-var cobraCommands map[string]*cobra.Command
-
+// RegisterCobraCmd registers a cobra command with the default Processor
 func RegisterCobraCmd(name string, cmd *cobra.Command) {
-	if cobraCommands == nil {
-		cobraCommands = make(map[string]*cobra.Command)
-	}
-	cobraCommands[name] = cmd
+	defaultProcessor.RegisterCobraCmd(name, cmd)
+}
+
+// RegisterCobraCmd registers a cobra command which can then be referenced
+// by name in a cobra:"name" tag
+func (p *Processor) RegisterCobraCmd(name string, cmd *cobra.Command) {
+	p.cobraCommands[name] = cmd
 }
 
+// ProcessCobraTags adds the flags for all cflag: tags in the struct to their cobra
+// commands registered with the default Processor
 func ProcessCobraTags(somestruct interface{}, opts *CobraFieldSubstOpts) (ret *ProcessedCobraTags, err error) {
+	return defaultProcessor.ProcessCobraTags(somestruct, opts)
+}
+
+// ProcessCobraTags adds the flags for all cflag: tags in the struct to their cobra
+// commands registered with the Processor
+func (p *Processor) ProcessCobraTags(somestruct interface{}, opts *CobraFieldSubstOpts) (ret *ProcessedCobraTags, err error) {
+	conv := p.converters
 	ret = &ProcessedCobraTags{}
 	if opts == nil {
 		opts = &CobraFieldSubstOpts{}
@@ -103,7 +146,92 @@ func ProcessCobraTags(somestruct interface{}, opts *CobraFieldSubstOpts) (ret *P
 	// 	myflags = flag.CommandLine
 	// }
 
-	setFlagVal := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, stag string, usagetag string, existing *cobraFlagSetRetriever, myflags []*flag.FlagSet) (retriever *cobraFlagSetRetriever, err error) {
+	// touchedBy adds a retriever which records the field as touched once cobra has
+	// parsed the flag, even if it was set to the value the field already had
+	touchedBy := func(path string, tag string, r *cobraFlagSetRetriever, myflags []*flag.FlagSet) {
+		r.retrievers = append(r.retrievers, func(flagname string, _ *cobraFlagSetRetriever) error {
+			for _, myflag := range myflags {
+				if !myflag.Changed(tag) {
+					continue
+				}
+				for _, touched := range ret.fieldsTouched {
+					if touched == path {
+						return nil
+					}
+				}
+				ret.fieldsTouched = append(ret.fieldsTouched, path)
+				return nil
+			}
+			return nil
+		})
+	}
+
+	// setFlagConverted adds a flag for a field of a type parsed by convertString, i.e. time.Duration
+	setFlagConverted := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, stag string, usagetag string, layout string, existing *cobraFlagSetRetriever, myflags []*flag.FlagSet) (retriever *cobraFlagSetRetriever, err error) {
+		retrieverfunc := func(flagname string, r *cobraFlagSetRetriever) (err error) {
+			if r.varconv != nil && r.varconv.set {
+				if r.varconv.val.Type() != fieldValue.Type() {
+					return fmt.Errorf("flag %s underlying interface{} type coercion failed", tag)
+				}
+				fieldValue.Set(r.varconv.val)
+			}
+			return nil
+		}
+		if existing != nil {
+			existing.retrievers = append(existing.retrievers, retrieverfunc)
+		} else {
+			retriever = &cobraFlagSetRetriever{fieldName: fieldName, fieldValue: fieldValue}
+			retriever.retrievers = append(retriever.retrievers, retrieverfunc)
+			retriever.varconv = &convertedFlagValue{conv: conv, t: fieldValue.Type(), layout: layout}
+			for _, myflag := range myflags {
+				if len(stag) > 0 {
+					myflag.VarP(retriever.varconv, tag, stag, usagetag)
+				} else {
+					myflag.Var(retriever.varconv, tag, usagetag)
+				}
+			}
+		}
+		return retriever, nil
+	}
+
+	// setFlagList adds a repeatable flag for a slice or map field, i.e. --peer a --peer b,c or --label a=1 --label b=2
+	setFlagList := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, stag string, usagetag string, layout string, confops map[string]string, existing *cobraFlagSetRetriever, myflags []*flag.FlagSet) (retriever *cobraFlagSetRetriever, err error) {
+		listval := conv.newListFlagValue(fieldValue.Type(), layout, confops)
+		if listval == nil {
+			return nil, fmt.Errorf("(flag) %s underlying type unsupported (setFlagList)", fieldValue.Type().String())
+		}
+		// the retrievers can be run more than once, but with conf:"append" the values must only be added once
+		var applied bool
+		retrieverfunc := func(flagname string, r *cobraFlagSetRetriever) (err error) {
+			if r.varlist != nil && !applied {
+				applied = r.varlist.apply(fieldValue)
+			}
+			return nil
+		}
+		if existing != nil {
+			existing.retrievers = append(existing.retrievers, retrieverfunc)
+		} else {
+			retriever = &cobraFlagSetRetriever{fieldName: fieldName, fieldValue: fieldValue}
+			retriever.retrievers = append(retriever.retrievers, retrieverfunc)
+			retriever.varlist = listval
+			for _, myflag := range myflags {
+				if len(stag) > 0 {
+					myflag.VarP(retriever.varlist, tag, stag, usagetag)
+				} else {
+					myflag.Var(retriever.varlist, tag, usagetag)
+				}
+			}
+		}
+		return retriever, nil
+	}
+
+	setFlagVal := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, stag string, usagetag string, layout string, confops map[string]string, existing *cobraFlagSetRetriever, myflags []*flag.FlagSet) (retriever *cobraFlagSetRetriever, err error) {
+		if conv.isConvertedType(fieldValue.Type()) {
+			return setFlagConverted(parentpath, fieldName, fieldValue, tag, stag, usagetag, layout, existing, myflags)
+		}
+		if fieldValue.Kind() == reflect.Slice || conv.isMapType(fieldValue.Type()) {
+			return setFlagList(parentpath, fieldName, fieldValue, tag, stag, usagetag, layout, confops, existing, myflags)
+		}
 		k := fieldValue.Kind()
 		switch k {
 		// TODO - add support for Ptr to String and Ints
@@ -216,7 +344,10 @@ func ProcessCobraTags(somestruct interface{}, opts *CobraFieldSubstOpts) (ret *P
 		return retriever, nil
 	}
 
-	setflagValPtr := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, stag string, usagetag string, existing *cobraFlagSetRetriever, myflags []*flag.FlagSet) (retriever *cobraFlagSetRetriever, err error) {
+	setflagValPtr := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, stag string, usagetag string, layout string, existing *cobraFlagSetRetriever, myflags []*flag.FlagSet) (retriever *cobraFlagSetRetriever, err error) {
+		if conv.isConvertedType(fieldValue.Elem().Type()) {
+			return setFlagConverted(parentpath, fieldName, fieldValue.Elem(), tag, stag, usagetag, layout, existing, myflags)
+		}
 		k := fieldValue.Elem().Kind()
 		switch k {
 		// TODO - add support for Ptr to String and Ints
@@ -536,7 +667,7 @@ func ProcessCobraTags(somestruct interface{}, opts *CobraFieldSubstOpts) (ret *P
 			// get pflags for the cobra command
 			//			if len(cobracmdtags) > 0 {
 			for _, cobracmdtag := range cobracmdtags {
-				cmd, ok := cobraCommands[cobracmdtag]
+				cmd, ok := p.cobraCommands[cobracmdtag]
 				if !ok {
 					return fmt.Errorf("field %s: cobra command %s not found", field.Name, cobracmdtag)
 				}
@@ -593,7 +724,7 @@ func ProcessCobraTags(somestruct interface{}, opts *CobraFieldSubstOpts) (ret *P
 					default:
 						debugf("cflag: Got a NON-fundamental type: %s %s which is a %s\n", t.Kind().String(), t.Elem().String(), t.Elem().Kind().String())
 						if fieldValue.CanSet() {
-							if t.Elem().Kind() == reflect.Struct {
+							if t.Elem().Kind() == reflect.Struct || conv.isConvertedType(t.Elem()) {
 								fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
 							} else {
 								if len(tag) > 0 {
@@ -609,7 +740,7 @@ func ProcessCobraTags(somestruct interface{}, opts *CobraFieldSubstOpts) (ret *P
 
 				if !fieldValue.IsNil() {
 					// is this a Ptr to a struct?
-					if t.Elem().Kind() == reflect.Struct {
+					if conv.isStructType(t.Elem()) {
 						err := findFlags(addParentPath(parentpath, field.Name), fieldValue.Elem().Addr().Interface())
 						if err != nil {
 							return err
@@ -619,23 +750,23 @@ func ProcessCobraTags(somestruct interface{}, opts *CobraFieldSubstOpts) (ret *P
 						if len(tag) > 0 {
 							existing, ok := ret.needflags[tag] // check if we already have a retriever for this flag
 							if ok {
-								_, err = setflagValPtr(parentpath, field.Name, fieldValue, tag, stag, usagetag, existing, allpflags)
+								_, err = setflagValPtr(parentpath, field.Name, fieldValue, tag, stag, usagetag, field.Tag.Get(LAYOUTFIELD), existing, allpflags)
 								if err != nil {
 									return
 								}
 							} else {
-								var retriever *cobraFlagSetRetriever
-								retriever, err = setflagValPtr(parentpath, field.Name, fieldValue, tag, stag, usagetag, nil, allpflags)
+								existing, err = setflagValPtr(parentpath, field.Name, fieldValue, tag, stag, usagetag, field.Tag.Get(LAYOUTFIELD), nil, allpflags)
 								if err != nil {
 									return
 								}
-								ret.needflags[tag] = retriever
+								ret.needflags[tag] = existing
 							}
+							touchedBy(addParentPath(parentpath, field.Name), tag, existing, allpflags)
 						}
 
 					}
 				}
-			} else if field.Type.Kind() == reflect.Struct {
+			} else if conv.isStructType(field.Type) {
 				// recurse
 				fieldValue := inputValue.FieldByName(field.Name)
 				// is this a Ptr to a struct?
@@ -647,18 +778,18 @@ func ProcessCobraTags(somestruct interface{}, opts *CobraFieldSubstOpts) (ret *P
 				if len(tag) > 0 {
 					existing, ok := ret.needflags[tag] // check if we already have a retriever for this flag
 					if ok {
-						_, err = setFlagVal(parentpath, field.Name, fieldValue, tag, stag, usagetag, existing, allpflags)
+						_, err = setFlagVal(parentpath, field.Name, fieldValue, tag, stag, usagetag, field.Tag.Get(LAYOUTFIELD), confops, existing, allpflags)
 						if err != nil {
 							return
 						}
 					} else {
-						var retriever *cobraFlagSetRetriever
-						retriever, err = setFlagVal(parentpath, field.Name, fieldValue, tag, stag, usagetag, nil, allpflags)
+						existing, err = setFlagVal(parentpath, field.Name, fieldValue, tag, stag, usagetag, field.Tag.Get(LAYOUTFIELD), confops, nil, allpflags)
 						if err != nil {
 							return
 						}
-						ret.needflags[tag] = retriever
+						ret.needflags[tag] = existing
 					}
+					touchedBy(addParentPath(parentpath, field.Name), tag, existing, allpflags)
 				}
 			} else {
 				if len(tag) > 0 {
@@ -714,16 +845,22 @@ func FinalizeCobraFlags(tags *ProcessedCobraTags) (err error) {
 }
 
 // Should be called before running Process. Call on each struct which may have 'cflags' or other cobra related conftagz
+// Uses the default Processor.
 func PreProcessCobraFlags(somestruct interface{}, opts *CobraFieldSubstOpts) (err error) {
+	return defaultProcessor.PreProcessCobraFlags(somestruct, opts)
+}
+
+// Should be called before running Process. Call on each struct which may have 'cflags' or other cobra related conftagz
+func (p *Processor) PreProcessCobraFlags(somestruct interface{}, opts *CobraFieldSubstOpts) (err error) {
 	var processed *ProcessedCobraTags
-	usingCobraFlags = true
-	_, ok := preprocessedCobraStructFlags[somestruct]
+	p.usingCobraFlags = true
+	_, ok := p.preprocessedCobraStructFlags[somestruct]
 	if !ok {
-		processed, err = ProcessCobraTags(somestruct, opts)
+		processed, err = p.ProcessCobraTags(somestruct, opts)
 		if err != nil {
 			return
 		}
-		preprocessedCobraStructFlags[somestruct] = processed
+		p.preprocessedCobraStructFlags[somestruct] = processed
 	}
 	// if opts != nil {
 	// 	if opts.Args != nil && len(opts.Args) > 0 {
@@ -757,8 +894,16 @@ func PreProcessCobraFlags(somestruct interface{}, opts *CobraFieldSubstOpts) (er
 	return
 }
 
+// PostProcessCobraFlags sets the struct fields for all structs given to PreProcessCobraFlags
+// after the cobra flags have been parsed. Uses the default Processor.
 func PostProcessCobraFlags() (err error) {
-	for _, v := range preprocessedCobraStructFlags {
+	return defaultProcessor.PostProcessCobraFlags()
+}
+
+// PostProcessCobraFlags sets the struct fields for all structs given to PreProcessCobraFlags
+// after the cobra flags have been parsed
+func (p *Processor) PostProcessCobraFlags() (err error) {
+	for _, v := range p.preprocessedCobraStructFlags {
 		err = FinalizeCobraFlags(v)
 		if err != nil {
 			return
@@ -803,12 +948,6 @@ func PostProcessCobraFlags() (err error) {
 // 	return
 // }
 
-var preprocessedCobraStructFlags map[interface{}]*ProcessedCobraTags
-
-func init() {
-	preprocessedCobraStructFlags = make(map[interface{}]*ProcessedCobraTags)
-}
-
 // Use this to add in all flags from a given struct *before* flags.Parse() is called anywhere.
 // flags.Parse could be handled by the caller later, OR it may be handled by the conftagz.Process()
 // function.
@@ -0,0 +1,164 @@
+package conftagz
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config file formats
+const (
+	FORMATYAML = "yaml"
+	FORMATJSON = "json"
+)
+
+type ConfigFileOpts struct {
+	// Paths are the candidate config files. The first one which exists is loaded.
+	Paths []string
+	// if set, and this env var exists, its value is the config file to load - ahead
+	// of anything in Paths
+	PathEnv string
+	// if set, and this flag is given on the command line, its value is the config file
+	// to load - ahead of PathEnv and Paths. The flag is added to the flag set used by the
+	// FLAGTAGS stage.
+	PathFlag string
+	// FORMATYAML or FORMATJSON. If empty the format is found from the file extension.
+	Format string
+	// throws an error if none of the Paths exist
+	MustExist bool
+	// the file which was loaded, set by LoadConfigFile
+	Loaded string
+	// the keys present in the file which was loaded, set by LoadConfigFile
+	Present PresentKeys
+}
+
+// formatFromPath returns the format of the file based on its extension
+func formatFromPath(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FORMATYAML, nil
+	case ".json":
+		return FORMATJSON, nil
+	}
+	return "", fmt.Errorf("config file %s: unknown format (extension should be .yaml, .yml or .json)", path)
+}
+
+// lookupFlagArg looks through command line args for the flag named name without parsing them,
+// so its value can be used before the flag set is parsed. Accepts -name val, --name val,
+// -name=val and --name=val.
+func lookupFlagArg(args []string, name string) (val string, ok bool) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if arg == name {
+			if i+1 < len(args) {
+				return args[i+1], true
+			}
+			return "", false
+		}
+		if strings.HasPrefix(arg, name+"=") {
+			return arg[len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+// LoadConfigFile finds the config file to use from the options, reads it, and unmarshals it
+// into somestruct. The file is picked from, in order: the PathFlag flag, the PathEnv env var,
+// and the first of Paths which exists. args are the command line args to look for PathFlag in,
+// if nil os.Args[1:] is used.
+// It returns the path of the file loaded, which is empty if no file was found.
+func LoadConfigFile(somestruct interface{}, opts *ConfigFileOpts, args []string) (path string, err error) {
+	if opts == nil {
+		return
+	}
+	// nothing from a file loaded earlier is kept
+	opts.Loaded = ""
+	opts.Present = nil
+	if args == nil {
+		args = os.Args[1:]
+	}
+
+	var explicit bool
+	if len(opts.PathFlag) > 0 {
+		path, explicit = lookupFlagArg(args, opts.PathFlag)
+	}
+	if !explicit && len(opts.PathEnv) > 0 {
+		path, explicit = os.LookupEnv(opts.PathEnv)
+	}
+	if !explicit {
+		for _, candidate := range opts.Paths {
+			if _, staterr := os.Stat(candidate); staterr == nil {
+				path = candidate
+				break
+			}
+		}
+	}
+	if len(path) < 1 {
+		if opts.MustExist {
+			err = fmt.Errorf("no config file found in %v", opts.Paths)
+		}
+		return
+	}
+
+	debugf("file: loading config file %s\n", path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && !explicit && !opts.MustExist {
+			return "", nil
+		}
+		return
+	}
+
+	format := opts.Format
+	if len(format) < 1 {
+		format, err = formatFromPath(path)
+		if err != nil {
+			return
+		}
+	}
+	switch format {
+	case FORMATYAML:
+		err = yaml.Unmarshal(data, somestruct)
+	case FORMATJSON:
+		err = json.Unmarshal(data, somestruct)
+	default:
+		err = fmt.Errorf("config file format %s unsupported", format)
+	}
+	if err == nil {
+		opts.Present, err = FindPresentKeys(data, format)
+	}
+	if err != nil {
+		err = fmt.Errorf("config file %s: %w", path, err)
+		return
+	}
+	opts.Loaded = path
+	return
+}
+
+// definePathFlag adds the PathFlag flag to the flag set, so parsing the command line
+// later does not fail on it
+func (opts *ConfigFileOpts) definePathFlag(set *flag.FlagSet) {
+	if len(opts.PathFlag) < 1 {
+		return
+	}
+	if set == nil {
+		set = flag.CommandLine
+	}
+	if set.Lookup(opts.PathFlag) == nil {
+		set.String(opts.PathFlag, "", "config file to load")
+	}
+}
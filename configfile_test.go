@@ -0,0 +1,159 @@
+package conftagz
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type FileConfig struct {
+	Host string `yaml:"host" json:"host" default:"localhost"`
+	Port int    `yaml:"port" json:"port" env:"FILECONF_PORT" default:"8080" test:">=1024"`
+	SSL  struct {
+		Cert string `yaml:"cert" json:"cert"`
+	} `yaml:"ssl" json:"ssl"`
+}
+
+func writeConfFile(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, []byte(content), 0600)
+	assert.Nil(t, err)
+	return path
+}
+
+func TestLoadConfigFileYamlAndJson(t *testing.T) {
+	dir := t.TempDir()
+	yml := writeConfFile(t, dir, "conf.yml", "host: example.com\nport: 2000\nssl:\n  cert: a.pem\n")
+	jsn := writeConfFile(t, dir, "conf.json", `{"host": "json.com", "port": 3000, "ssl": {"cert": "b.pem"}}`)
+
+	var c1 FileConfig
+	path, err := LoadConfigFile(&c1, &ConfigFileOpts{Paths: []string{yml}}, []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, yml, path)
+	assert.Equal(t, "example.com", c1.Host)
+	assert.Equal(t, 2000, c1.Port)
+	assert.Equal(t, "a.pem", c1.SSL.Cert)
+
+	var c2 FileConfig
+	path, err = LoadConfigFile(&c2, &ConfigFileOpts{Paths: []string{jsn}}, []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, jsn, path)
+	assert.Equal(t, "json.com", c2.Host)
+	assert.Equal(t, 3000, c2.Port)
+	assert.Equal(t, "b.pem", c2.SSL.Cert)
+
+	// forced format
+	other := writeConfFile(t, dir, "conf.cfg", "port: 2500\n")
+	var c3 FileConfig
+	_, err = LoadConfigFile(&c3, &ConfigFileOpts{Paths: []string{other}}, []string{})
+	assert.NotNil(t, err)
+	_, err = LoadConfigFile(&c3, &ConfigFileOpts{Paths: []string{other}, Format: FORMATYAML}, []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2500, c3.Port)
+}
+
+func TestLoadConfigFileCandidates(t *testing.T) {
+	dir := t.TempDir()
+	second := writeConfFile(t, dir, "second.yaml", "port: 2001\n")
+	missing := filepath.Join(dir, "first.yaml")
+
+	var c FileConfig
+	path, err := LoadConfigFile(&c, &ConfigFileOpts{Paths: []string{missing, second}}, []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, second, path)
+	assert.Equal(t, 2001, c.Port)
+
+	// nothing found is fine unless MustExist
+	path, err = LoadConfigFile(&c, &ConfigFileOpts{Paths: []string{missing}}, []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, "", path)
+	_, err = LoadConfigFile(&c, &ConfigFileOpts{Paths: []string{missing}, MustExist: true}, []string{})
+	assert.NotNil(t, err)
+}
+
+func TestLoadConfigFilePathFromFlagAndEnv(t *testing.T) {
+	dir := t.TempDir()
+	dflt := writeConfFile(t, dir, "default.yaml", "port: 2001\n")
+	fromenv := writeConfFile(t, dir, "env.yaml", "port: 2002\n")
+	fromflag := writeConfFile(t, dir, "flag.yaml", "port: 2003\n")
+
+	opts := &ConfigFileOpts{Paths: []string{dflt}, PathEnv: "FILECONF_PATH", PathFlag: "config"}
+
+	var c FileConfig
+	_, err := LoadConfigFile(&c, opts, []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2001, c.Port)
+
+	os.Setenv("FILECONF_PATH", fromenv)
+	defer os.Unsetenv("FILECONF_PATH")
+	_, err = LoadConfigFile(&c, opts, []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2002, c.Port)
+
+	for _, args := range [][]string{
+		{"-config", fromflag},
+		{"--config", fromflag},
+		{"-port", "5", "-config=" + fromflag},
+		{"--config=" + fromflag},
+	} {
+		c.Port = 0
+		path, err := LoadConfigFile(&c, opts, args)
+		assert.Nil(t, err)
+		assert.Equal(t, fromflag, path)
+		assert.Equal(t, 2003, c.Port)
+	}
+
+	// an explicit path which does not exist is an error
+	_, err = LoadConfigFile(&c, opts, []string{"-config", filepath.Join(dir, "nope.yaml")})
+	assert.NotNil(t, err)
+	// args after -- are not flags
+	_, err = LoadConfigFile(&c, opts, []string{"--", "-config", fromflag})
+	assert.Nil(t, err)
+	assert.Equal(t, 2002, c.Port)
+}
+
+func TestProcessConfigFileStage(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfFile(t, dir, "conf.yaml", "host: example.com\nport: 2000\n")
+	os.Setenv("FILECONF_PORT", "4000")
+	defer os.Unsetenv("FILECONF_PORT")
+
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	prov := Provenance{}
+	var c FileConfig
+	err := NewProcessor(nil).Process(&ConfTagOpts{
+		FileOpts: &ConfigFileOpts{PathFlag: "config"},
+		FlagTagOpts: &FlagFieldSubstOpts{
+			UseFlags: flagset,
+			Args:     []string{"-config", path},
+		},
+		Provenance: prov,
+	}, &c)
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com", c.Host)
+	// env overrides the file
+	assert.Equal(t, 4000, c.Port)
+	// the flag was defined so parsing did not fail on it
+	assert.NotNil(t, flagset.Lookup("config"))
+
+	assert.Equal(t, STAGEFILE, prov["Host"].Stage)
+	assert.Equal(t, path, prov["Host"].Key)
+	assert.Equal(t, STAGEENV, prov["Port"].Stage)
+	assert.Equal(t, []ValueSource{{Stage: STAGEFILE, Key: path, Value: 2000}}, prov["Port"].Overrode)
+}
+
+func TestProcessConfigFileStageError(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfFile(t, dir, "conf.yaml", "port: [1, 2\n")
+
+	var c FileConfig
+	err := NewProcessor(nil).Process(&ConfTagOpts{
+		OrderOfOps: []int{CONFIGFILE, DEFAULTTAGS, TESTTAGS},
+		FileOpts:   &ConfigFileOpts{Paths: []string{path}},
+	}, &c)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, c.Port)
+}
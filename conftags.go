@@ -1,5 +1,10 @@
 package conftagz
 
+import (
+	"flag"
+	"fmt"
+)
+
 // Constants to define flag tag types
 const (
 	_ int = iota
@@ -8,18 +13,19 @@ const (
 	ENVTAGS
 	DEFAULTTAGS
 	TESTTAGS
+	CONFIGFILE
+	DIRECTORY
+	INTERPOLATE
 )
 
 func defaultOrderOfOps() []int {
-	return []int{DEFAULTTAGS, ENVTAGS, FLAGTAGS, TESTTAGS}
+	return []int{CONFIGFILE, DIRECTORY, INTERPOLATE, DEFAULTTAGS, ENVTAGS, FLAGTAGS, TESTTAGS}
 }
 
-var usingCobraFlags bool
-
 // mostly just used for testing the library. Returns library to the state it should be on
 // initial load
 func ResetGlobals() {
-	usingCobraFlags = false
+	defaultProcessor.usingCobraFlags = false
 }
 
 // this just takes the current order of ops,
@@ -45,71 +51,361 @@ type ConfTagOpts struct {
 	DefaultOpts  *DefaultFieldSubstOpts
 	FlagTagOpts  *FlagFieldSubstOpts
 	CobraTagOpts *CobraFieldSubstOpts
+	// if set, the CONFIGFILE stage loads a yaml or json config file into the struct
+	FileOpts *ConfigFileOpts
+	// if set, the DIRECTORY stage sets fields from a directory with one file per key,
+	// i.e. a mounted Kubernetes ConfigMap
+	DirOpts *DirFieldSubstOpts
+	// if set, the INTERPOLATE stage expands ${VAR} in the string fields. By default it runs
+	// after the config file and directory are loaded.
+	InterpOpts *InterpolateOpts
+	// if true, Process does not stop at the first field which fails. All stages are run
+	// and a *MultiError listing every failing field is returned
+	CollectErrors bool
+	// if not nil, Process fills this in with where the value of every field came from
+	Provenance Provenance
+}
+
+// clone copies the options, and the options of each stage, so Process can fill
+// them in without changing the originals
+func (opts *ConfTagOpts) clone() *ConfTagOpts {
+	c := *opts
+	if opts.EnvOpts != nil {
+		envopts := *opts.EnvOpts
+		c.EnvOpts = &envopts
+	}
+	if opts.TestOpts != nil {
+		testopts := *opts.TestOpts
+		c.TestOpts = &testopts
+	}
+	if opts.DefaultOpts != nil {
+		defaultopts := *opts.DefaultOpts
+		c.DefaultOpts = &defaultopts
+	}
+	if opts.FlagTagOpts != nil {
+		flagopts := *opts.FlagTagOpts
+		c.FlagTagOpts = &flagopts
+	}
+	if opts.CobraTagOpts != nil {
+		cobraopts := *opts.CobraTagOpts
+		c.CobraTagOpts = &cobraopts
+	}
+	if opts.FileOpts != nil {
+		fileopts := *opts.FileOpts
+		c.FileOpts = &fileopts
+	}
+	if opts.DirOpts != nil {
+		diropts := *opts.DirOpts
+		c.DirOpts = &diropts
+	}
+	if opts.InterpOpts != nil {
+		interpopts := *opts.InterpOpts
+		c.InterpOpts = &interpopts
+	}
+	return &c
 }
 
 // Process takes a struct and processes the tags in the struct
+// using the default Processor
 func Process(opts *ConfTagOpts, somestruct interface{}) (err error) {
+	return defaultProcessor.Process(opts, somestruct)
+}
+
+// Process takes a struct and processes the tags in the struct. If opts is nil
+// the Processor's own Opts are used.
+func (p *Processor) Process(opts *ConfTagOpts, somestruct interface{}) (err error) {
 	if opts == nil {
 		opts = &ConfTagOpts{}
+		if p.Opts != nil {
+			opts = p.Opts
+		}
 	}
+	// copied, with the options of every stage, so the caller's options and the
+	// Processor's defaults are not filled in below and each call starts afresh
+	opts = opts.clone()
 	if opts.OrderOfOps == nil {
 		opts.OrderOfOps = defaultOrderOfOps()
-		if usingCobraFlags {
+		if p.usingCobraFlags {
 			opts.OrderOfOps = switchOrderOfOpsToCobra()
 		}
 	}
 
+	var errs MultiError
+	// stageFailed records the error from a stage. Returns an error only if processing
+	// should stop.
+	stageFailed := func(stage string, err error) error {
+		if err == nil || !opts.CollectErrors {
+			return err
+		}
+		if _, ok := err.(*MultiError); !ok {
+			err = fmt.Errorf("%s: %w", stage, err)
+		}
+		errs.append(err)
+		return nil
+	}
+
+	var track *provenanceTracker
+	if opts.Provenance != nil {
+		track = newProvenanceTracker(p.converters, opts.Provenance, somestruct)
+	}
+	order, err := newOrderTracker(p.converters, somestruct)
+	if err != nil {
+		return
+	}
+	// stageDone records the fields set by a stage
+	stageDone := func(stage string, touched []string) {
+		if track != nil {
+			track.update(stage, touched)
+		}
+		if order != nil {
+			order.update(stage, touched)
+		}
+	}
+	// defaultsFor applies the defaults of the fields only is true for, once the default
+	// stage has run. Returns an error only if processing should stop.
+	var defaultsDone bool
+	defaultsFor := func(only func(path string, defaultval string) bool) error {
+		if !defaultsDone {
+			return nil
+		}
+		defaultopts := *opts.DefaultOpts
+		defaultopts.only = only
+		touched, err := p.SubsistuteDefaults(somestruct, &defaultopts)
+		if track != nil {
+			track.update(STAGEDEFAULT, touched)
+		}
+		return stageFailed(STAGEDEFAULT, err)
+	}
+
+	// resolveOrder sets the fields with a conf:"order=..." tag to the value from their
+	// highest precedence source. Done once, before the tests.
+	var orderResolved bool
+	resolveOrder := func() error {
+		if order == nil || orderResolved {
+			return nil
+		}
+		orderResolved = true
+		unset, err := order.resolve(func(source string) {
+			if track != nil {
+				track.update(source, nil)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		// no source in the order gave these a value, so they get their own default
+		return defaultsFor(func(path string, defaultval string) bool {
+			return unset[path]
+		})
+	}
+
+	// refDefaults applies the defaults which refer to other fields, i.e. ${.Host}, once
+	// the env vars and flags have set those. Done once, before the tests.
+	var refDefaultsDone bool
+	refDefaults := func() error {
+		if refDefaultsDone {
+			return nil
+		}
+		refDefaultsDone = true
+		return defaultsFor(func(path string, defaultval string) bool {
+			// a field with a conf:"order=..." tag got its default from resolveOrder
+			return hasFieldRefs(defaultval) && (order == nil || order.fields[path] == nil)
+		})
+	}
+
+	// once the env stage has run, fields tagged conf:"preferenv" are put back
+	// to their env var after every later stage which could have changed them
+	var envDone bool
+	reapplyPreferEnv := func() {
+		if !envDone {
+			return
+		}
+		// copied so the env vars are named the same way as in the env stage
+		envopts := &EnvFieldSubstOpts{}
+		if opts.EnvOpts != nil {
+			*envopts = *opts.EnvOpts
+		}
+		envopts.preferOnly = true
+		envopts.converters = p.converters
+		envopts.CollectErrors = true
+		// any error was already reported by the env stage
+		_, _ = EnvFieldSubstitution(somestruct, envopts)
+		stageDone(STAGEENV, nil)
+	}
+
 	for _, op := range opts.OrderOfOps {
 		switch op {
 
+		case CONFIGFILE:
+			if opts.FileOpts == nil {
+				continue
+			}
+			debugf("Processing config file\n")
+			var args []string
+			if opts.FlagTagOpts != nil {
+				args = opts.FlagTagOpts.Args
+			}
+			if !p.usingCobraFlags {
+				var set *flag.FlagSet
+				if opts.FlagTagOpts != nil {
+					set = opts.FlagTagOpts.UseFlags
+				}
+				opts.FileOpts.definePathFlag(set)
+			}
+			var loaded string
+			loaded, err = LoadConfigFile(somestruct, opts.FileOpts, args)
+			if track != nil {
+				track.file = loaded
+			}
+			stageDone(STAGEFILE, nil)
+			if err = stageFailed(STAGEFILE, err); err != nil {
+				return
+			}
+			reapplyPreferEnv()
+
+		case DIRECTORY:
+			if opts.DirOpts == nil {
+				continue
+			}
+			debugf("Processing directory %s\n", opts.DirOpts.Dir)
+			opts.DirOpts.converters = p.converters
+			if opts.CollectErrors {
+				opts.DirOpts.CollectErrors = true
+			}
+			if track != nil {
+				if opts.DirOpts.UsedNames == nil {
+					opts.DirOpts.UsedNames = make(map[string]string)
+				}
+				track.keys[STAGEDIR] = opts.DirOpts.UsedNames
+			}
+			var touched []string
+			touched, err = DirFieldSubstitution(somestruct, opts.DirOpts)
+			stageDone(STAGEDIR, touched)
+			if err = stageFailed(STAGEDIR, err); err != nil {
+				return
+			}
+			reapplyPreferEnv()
+
+		case INTERPOLATE:
+			if opts.InterpOpts == nil {
+				continue
+			}
+			debugf("Processing ${VAR} interpolation\n")
+			opts.InterpOpts.converters = p.converters
+			if opts.CollectErrors {
+				opts.InterpOpts.CollectErrors = true
+			}
+			var touched []string
+			touched, err = InterpolateFields(somestruct, opts.InterpOpts)
+			stageDone(STAGEINTERP, touched)
+			if err = stageFailed(STAGEINTERP, err); err != nil {
+				return
+			}
+
 		case FLAGTAGS:
 			debugf("Processing flag: tags\n")
 			if opts.FlagTagOpts == nil {
 				opts.FlagTagOpts = &FlagFieldSubstOpts{}
 			}
-			err = ProcessFlags(somestruct, opts.FlagTagOpts)
-			if err != nil {
+			err = p.ProcessFlags(somestruct, opts.FlagTagOpts)
+			var touched []string
+			if tags := p.preprocessedStructFlags[somestruct]; tags != nil {
+				touched = tags.GetFieldsTouched()
+			}
+			stageDone(STAGEFLAG, touched)
+			if err = stageFailed(STAGEFLAG, err); err != nil {
 				return
 			}
+			reapplyPreferEnv()
 		case COBRATAGS:
 			debugf("Processing cobra: tags\n")
 			if opts.FlagTagOpts == nil {
 				opts.FlagTagOpts = &FlagFieldSubstOpts{}
 			}
 			//			_, err = ProcessCobraTags(somestruct, opts.CobraTagOpts)
-			err = PostProcessCobraFlags()
-			if err != nil {
+			err = p.PostProcessCobraFlags()
+			var touched []string
+			if tags := p.preprocessedCobraStructFlags[somestruct]; tags != nil {
+				touched = tags.GetFieldsTouched()
+			}
+			stageDone(STAGEFLAG, touched)
+			if err = stageFailed(STAGEFLAG, err); err != nil {
 				return
 			}
+			reapplyPreferEnv()
 		case ENVTAGS:
 			debugf("Processing env: tags\n")
 			if opts.EnvOpts == nil {
 				opts.EnvOpts = &EnvFieldSubstOpts{}
 			}
-			_, err = EnvFieldSubstitution(somestruct, opts.EnvOpts)
-			if err != nil {
+			opts.EnvOpts.converters = p.converters
+			if opts.CollectErrors {
+				opts.EnvOpts.CollectErrors = true
+			}
+			if track != nil {
+				if opts.EnvOpts.UsedNames == nil {
+					opts.EnvOpts.UsedNames = make(map[string]string)
+				}
+				track.keys[STAGEENV] = opts.EnvOpts.UsedNames
+			}
+			var touched []string
+			touched, err = EnvFieldSubstitution(somestruct, opts.EnvOpts)
+			stageDone(STAGEENV, touched)
+			if err = stageFailed(STAGEENV, err); err != nil {
 				return
 			}
+			envDone = true
 		case DEFAULTTAGS:
 			debugf("Processing default: tags\n")
 			if opts.DefaultOpts == nil {
 				opts.DefaultOpts = &DefaultFieldSubstOpts{}
 			}
-			_, err = SubsistuteDefaults(somestruct, opts.DefaultOpts)
-			if err != nil {
+			// the keys present in the file loaded by this call, unless the caller gave them
+			if opts.DefaultOpts.Present == nil && opts.FileOpts != nil {
+				opts.DefaultOpts.Present = opts.FileOpts.Present
+			}
+			if opts.CollectErrors {
+				opts.DefaultOpts.CollectErrors = true
+			}
+			// the defaults which refer to other fields wait for refDefaults
+			defaultopts := *opts.DefaultOpts
+			defaultopts.only = func(path string, defaultval string) bool {
+				return !hasFieldRefs(defaultval)
+			}
+			var touched []string
+			touched, err = p.SubsistuteDefaults(somestruct, &defaultopts)
+			stageDone(STAGEDEFAULT, touched)
+			if err = stageFailed(STAGEDEFAULT, err); err != nil {
 				return
 			}
+			defaultsDone = true
+			reapplyPreferEnv()
 		case TESTTAGS:
+			if err = resolveOrder(); err != nil {
+				return
+			}
+			if err = refDefaults(); err != nil {
+				return
+			}
 			debugf("Processing test: tags\n")
 			if opts.TestOpts == nil {
 				opts.TestOpts = &TestFieldSubstOpts{}
 			}
-			_, err = RunTestFlags(somestruct, opts.TestOpts)
-			if err != nil {
+			if opts.CollectErrors {
+				opts.TestOpts.CollectErrors = true
+			}
+			_, err = p.RunTestFlags(somestruct, opts.TestOpts)
+			if err = stageFailed(STAGETEST, err); err != nil {
 				return
 			}
 		}
 	}
 
-	return
+	if err = resolveOrder(); err != nil {
+		return
+	}
+	if err = refDefaults(); err != nil {
+		return
+	}
+	return errs.errOrNil()
 }
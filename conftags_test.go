@@ -1,6 +1,7 @@
 package conftagz
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"testing"
@@ -162,3 +163,30 @@ func TestProcessCobraTags(t *testing.T) {
 	assert.Equal(t, mystruct.Field3, 8888)
 	assert.Equal(t, false, mystruct.Field4)
 }
+
+func TestProcessCollectErrors(t *testing.T) {
+	t.Setenv("MULTI_PORT", "eighty")
+	mystruct := &MultiErrStruct{Name: "UPPER"}
+
+	err := Process(&ConfTagOpts{
+		OrderOfOps:    []int{ENVTAGS, DEFAULTTAGS, TESTTAGS},
+		CollectErrors: true,
+	}, mystruct)
+
+	var multi *MultiError
+	if !errors.As(err, &multi) {
+		t.Errorf("Expected a *MultiError, got %v", err)
+		return
+	}
+	assert.EqualError(t, err, "4 errors:\n"+
+		"\tenv: field Port: map (env) MULTI_PORT value eighty not a number\n"+
+		"\tdefault: field Retries: default value notanumber not a int\n"+
+		"\ttest: field Port: value 0 ! >= 1024\n"+
+		"\ttest: field Name: value \"UPPER\" !~ regexp ^[a-z]+$")
+
+	// and without it, stop at the first
+	err = Process(&ConfTagOpts{
+		OrderOfOps: []int{ENVTAGS, DEFAULTTAGS, TESTTAGS},
+	}, &MultiErrStruct{})
+	assert.EqualError(t, err, "field Port: map (env) MULTI_PORT value eighty not a number")
+}
@@ -4,17 +4,30 @@ import "strings"
 
 // func NewConfTagOptsMap (opts []ConfTagOpts) map[string]string {
 
+// the conf: options which are not key=value
+var confOptions = map[string]bool{
+	"skip": true, "skipnil": true, "skipzero": true, "nildefault": true, "zeroisvalid": true,
+	"envskip": true, "defaultskip": true, "testskip": true, "append": true,
+	"mustenv": true, "preferenv": true, "backupenv": true, "testwarn": true, "nointerp": true,
+}
+
 func processConfTagOptsValues(conftags string) map[string]string {
 	confMap := make(map[string]string)
 
 	if len(conftags) > 0 {
 		conftagops := strings.Split(conftags, ",")
+		var lastKey string
 		for _, conftagop := range conftagops {
 			pair := strings.SplitN(strings.TrimSpace(conftagop), "=", 2)
 			if len(pair) == 2 {
 				confMap[pair[0]] = pair[1]
+				lastKey = pair[0]
+			} else if lastKey == ORDEROPT && !confOptions[pair[0]] {
+				// the rest of an order=file,env,flag list
+				confMap[ORDEROPT] += "," + pair[0]
 			} else {
 				confMap[pair[0]] = ""
+				lastKey = pair[0]
 			}
 		}
 	}
@@ -48,6 +61,15 @@ func skipIfNil(confops map[string]string) bool {
 	return false
 }
 
+// a zero value for this field is a real value if the key was present in the
+// config file, so the default is not used
+func zeroIsValid(confops map[string]string) bool {
+	if _, ok := confops["zeroisvalid"]; ok {
+		return true
+	}
+	return false
+}
+
 func skipIfZero(confops map[string]string) bool {
 	if _, ok := confops["skipzero"]; ok {
 		return true
@@ -56,7 +78,7 @@ func skipIfZero(confops map[string]string) bool {
 }
 
 // true if the env var must exist, returns an error if it does not
-func mustEnv(confops map[string]string) bool { // nolint:golint,unused
+func mustEnv(confops map[string]string) bool {
 	if _, ok := confops["mustenv"]; ok {
 		return true
 	}
@@ -89,7 +111,7 @@ func testSkip(confops map[string]string) bool {
 
 // true if the envvar should always replace a value
 // if the env var exists
-func preferEnv(confops map[string]string) bool { // nolint:golint,unused
+func preferEnv(confops map[string]string) bool {
 	if _, ok := confops["preferenv"]; ok {
 		return true
 	}
@@ -97,7 +119,7 @@ func preferEnv(confops map[string]string) bool { // nolint:golint,unused
 }
 
 // if true then env var is only used if the field has the zero value
-func backupEnv(confops map[string]string) bool { // nolint:golint,unused
+func backupEnv(confops map[string]string) bool {
 	if _, ok := confops["backupenv"]; ok {
 		return true
 	}
@@ -106,9 +128,44 @@ func backupEnv(confops map[string]string) bool { // nolint:golint,unused
 
 // if true, then this test will only warn and never
 // cause an error to return
-func testWarn(confops map[string]string) bool { // nolint:golint,unused
+func testWarn(confops map[string]string) bool {
 	if _, ok := confops["testwarn"]; ok {
 		return true
 	}
 	return false
 }
+
+// the separator for the items of a list in an env var, flag or default,
+// i.e. conf:"sep=;". DEFAULTSEP if not given.
+func listSep(confops map[string]string) string {
+	if sep, ok := confops["sep"]; ok && len(sep) > 0 {
+		return sep
+	}
+	return DEFAULTSEP
+}
+
+// if true, a list from an env var or flag is added to the slice or map
+// instead of replacing it
+func appendList(confops map[string]string) bool {
+	if _, ok := confops["append"]; ok {
+		return true
+	}
+	return false
+}
+
+// the sources for the field in order of precedence, i.e. conf:"order=file,env,flag,default"
+func fieldOrder(confops map[string]string) []string {
+	order, ok := confops[ORDEROPT]
+	if !ok {
+		return nil
+	}
+	return strings.Split(order, ",")
+}
+
+// true if ${VAR} is left alone in the field, and in the fields of a struct
+func noInterp(confops map[string]string) bool {
+	if _, ok := confops["nointerp"]; ok {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package conftagz
+
+import (
+	"encoding"
+	"flag"
+	"fmt"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+// LAYOUTFIELD is the tag giving the time.Parse layout for a time.Time field.
+// If not given, time.RFC3339 is used.
+const LAYOUTFIELD = "layout"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+var timeType = reflect.TypeOf(time.Time{})
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+var flagValueType = reflect.TypeOf((*flag.Value)(nil)).Elem()
+
+// ConverterFunc converts the string from an env var, default: tag or flag to
+// a value of the type it was registered for. It may return either a value of
+// that type or a pointer to one.
+type ConverterFunc func(s string) (interface{}, error)
+
+// converterMap holds the converters registered with a Processor. The helpers which
+// decide how a type is parsed are methods on it, as a registered converter changes
+// the answer.
+type converterMap map[reflect.Type]ConverterFunc
+
+// builtinConverters returns the converters every Processor starts with
+func builtinConverters() converterMap {
+	return converterMap{
+		// *url.URL does not implement encoding.TextUnmarshaler
+		reflect.TypeOf(url.URL{}): func(s string) (interface{}, error) {
+			return url.Parse(s)
+		},
+	}
+}
+
+// RegisterConverter registers f with the default Processor to convert strings
+// to values of type t
+//
+//	conftagz.RegisterConverter(reflect.TypeOf(Color(0)), func(s string) (interface{}, error) {
+//		return ParseColor(s)
+//	})
+func RegisterConverter(t reflect.Type, f ConverterFunc) {
+	defaultProcessor.RegisterConverter(t, f)
+}
+
+// RegisterConverter registers f to convert strings to values of type t, for every
+// env:, default:, flag: and cflag: tag on a field of type t or *t. A converter takes
+// precedence over the type's own UnmarshalText or Set methods.
+func (p *Processor) RegisterConverter(t reflect.Type, f ConverterFunc) {
+	p.converters[t] = f
+}
+
+// orDefault returns the converters, or those of the default Processor if there are
+// none, i.e. when a stage is run directly rather than by Process
+func (c converterMap) orDefault() converterMap {
+	if c == nil {
+		return defaultProcessor.converters
+	}
+	return c
+}
+
+// isConvertedType is true for types which are set by parsing a string with
+// convertString, rather than by looking at their reflect.Kind - i.e. a time.Duration
+// is an int64 but is written as "1h30m". These are:
+//   - time.Duration and time.Time
+//   - types with a converter from RegisterConverter
+//   - types where a pointer to the type implements encoding.TextUnmarshaler or flag.Value,
+//     i.e. net.IP, netip.Prefix or slog.Level
+func (c converterMap) isConvertedType(t reflect.Type) bool {
+	if t == durationType || t == timeType {
+		return true
+	}
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return false
+	}
+	if c[t] != nil {
+		return true
+	}
+	pt := reflect.PointerTo(t)
+	return pt.Implements(textUnmarshalerType) || pt.Implements(flagValueType)
+}
+
+// isStructType is true if t is a struct which should be walked into, i.e.
+// not a time.Time
+func (c converterMap) isStructType(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !c.isConvertedType(t)
+}
+
+// convertString parses s into a value of type t, which must be one where
+// isConvertedType is true. layout is only used for time.Time.
+func (c converterMap) convertString(t reflect.Type, s string, layout string) (ret reflect.Value, err error) {
+	if f := c[t]; f != nil {
+		var v interface{}
+		v, err = f(s)
+		if err != nil {
+			return
+		}
+		ret = reflect.ValueOf(v)
+		if ret.IsValid() && ret.Type() == reflect.PointerTo(t) && !ret.IsNil() {
+			ret = ret.Elem()
+		}
+		if !ret.IsValid() || ret.Type() != t {
+			err = fmt.Errorf("converter for %s returned a %T", t.String(), v)
+		}
+		return
+	}
+	switch t {
+	case durationType:
+		var d time.Duration
+		d, err = time.ParseDuration(s)
+		if err != nil {
+			err = fmt.Errorf("not a duration")
+			return
+		}
+		return reflect.ValueOf(d), nil
+	case timeType:
+		if len(layout) < 1 {
+			layout = time.RFC3339
+		}
+		var tm time.Time
+		tm, err = time.Parse(layout, s)
+		if err != nil {
+			err = fmt.Errorf("not a time (layout %s)", layout)
+			return
+		}
+		return reflect.ValueOf(tm), nil
+	}
+	ptr := reflect.New(t)
+	switch v := ptr.Interface().(type) {
+	case encoding.TextUnmarshaler:
+		err = v.UnmarshalText([]byte(s))
+	case flag.Value:
+		err = v.Set(s)
+	default:
+		err = fmt.Errorf("no conversion from string to %s", t.String())
+	}
+	if err != nil {
+		return
+	}
+	return ptr.Elem(), nil
+}
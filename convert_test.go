@@ -0,0 +1,332 @@
+package conftagz
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+type TimeStruct struct {
+	Timeout   time.Duration   `env:"TIME_TIMEOUT" default:"30s" flag:"timeout" test:">=1s,<=24h"`
+	Retry     *time.Duration  `env:"TIME_RETRY" default:"5s"`
+	Backoffs  []time.Duration `default:"1s,2s,4s"`
+	Start     time.Time       `env:"TIME_START" default:"2024-01-02T03:04:05Z" flag:"start" test:">=2024-01-01T00:00:00Z"`
+	Day       *time.Time      `env:"TIME_DAY" layout:"2006-01-02" flag:"day"`
+	Expires   *time.Time
+	Intervals struct {
+		Poll time.Duration `env:"TIME_POLL" default:"1m"`
+	}
+}
+
+func TestTimeTypesDefaults(t *testing.T) {
+	var s TimeStruct
+	_, err := SubsistuteDefaults(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 30*time.Second, s.Timeout)
+	assert.Equal(t, 5*time.Second, *s.Retry)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, s.Backoffs)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), s.Start)
+	assert.Nil(t, s.Day)
+	assert.Nil(t, s.Expires)
+	assert.Equal(t, time.Minute, s.Intervals.Poll)
+
+	_, err = SubsistuteDefaults(&struct {
+		D time.Duration `default:"soon"`
+	}{}, nil)
+	assert.EqualError(t, err, "field D: default value soon not a duration")
+}
+
+func TestTimeTypesDefaultFunc(t *testing.T) {
+	p := NewProcessor(nil)
+	p.RegisterDefaultFunc("timeoutdefault", func(fieldname string) interface{} {
+		return 90 * time.Second
+	})
+	p.RegisterDefaultFunc("wrongtype", func(fieldname string) interface{} {
+		return int64(90)
+	})
+	s := struct {
+		D time.Duration `default:"$(timeoutdefault)"`
+	}{}
+	_, err := p.SubsistuteDefaults(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 90*time.Second, s.D)
+
+	s2 := struct {
+		D time.Duration `default:"$(wrongtype)"`
+	}{}
+	_, err = p.SubsistuteDefaults(&s2, nil)
+	assert.EqualError(t, err, "field D: default func wrongtype did not return a time.Duration")
+}
+
+func TestTimeTypesEnv(t *testing.T) {
+	var s TimeStruct
+	m := map[string]string{
+		"TIME_TIMEOUT": "1h30m",
+		"TIME_RETRY":   "250ms",
+		"TIME_START":   "2025-06-01T12:00:00+02:00",
+		"TIME_DAY":     "2025-06-02",
+		"TIME_POLL":    "10s",
+	}
+	envopts := &EnvFieldSubstOpts{converters: newColorProcessor().converters}
+	touched, err := EnvFieldSubstitutionFromMap(&s, envopts, m)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"Timeout", "Retry", "Start", "Day", "Intervals.Poll"}, touched)
+	assert.Equal(t, 90*time.Minute, s.Timeout)
+	assert.Equal(t, 250*time.Millisecond, *s.Retry)
+	assert.True(t, time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC).Equal(s.Start))
+	assert.Equal(t, time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC), *s.Day)
+	assert.Nil(t, s.Expires)
+	assert.Equal(t, 10*time.Second, s.Intervals.Poll)
+
+	_, err = EnvFieldSubstitutionFromMap(&s, nil, map[string]string{"TIME_TIMEOUT": "90"})
+	assert.EqualError(t, err, "field Timeout: map (env) TIME_TIMEOUT value 90 not a duration")
+	_, err = EnvFieldSubstitutionFromMap(&s, nil, map[string]string{"TIME_DAY": "06/02/2025"})
+	assert.EqualError(t, err, "field Day: map (env) TIME_DAY value 06/02/2025 not a time (layout 2006-01-02)")
+}
+
+func TestTimeTypesFlags(t *testing.T) {
+	var s TimeStruct
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := NewProcessor(nil).ProcessFlags(&s, &FlagFieldSubstOpts{
+		UseFlags: flagset,
+		Args:     []string{"-timeout", "2m", "-start", "2025-01-01T00:00:00Z", "-day", "2025-03-04"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Minute, s.Timeout)
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), s.Start)
+	assert.Equal(t, time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC), *s.Day)
+
+	var s2 TimeStruct
+	flagset = flag.NewFlagSet("test", flag.ContinueOnError)
+	err = NewProcessor(nil).ProcessFlags(&s2, &FlagFieldSubstOpts{
+		UseFlags: flagset,
+		Args:     []string{"-timeout", "120"},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestTimeTypesCobra(t *testing.T) {
+	s := struct {
+		Timeout time.Duration `cflag:"timeout,t" cobra:"root"`
+		Start   time.Time     `cflag:"start" cobra:"root" layout:"2006-01-02"`
+	}{}
+	rootCmd := &cobra.Command{Use: "app"}
+	p := NewProcessor(nil)
+	p.RegisterCobraCmd("root", rootCmd)
+	err := p.PreProcessCobraFlags(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "duration", rootCmd.Flags().Lookup("timeout").Value.Type())
+	err = rootCmd.ParseFlags([]string{"-t", "45s", "--start", "2025-05-06"})
+	assert.Nil(t, err)
+	err = p.PostProcessCobraFlags()
+	assert.Nil(t, err)
+	assert.Equal(t, 45*time.Second, s.Timeout)
+	assert.Equal(t, time.Date(2025, 5, 6, 0, 0, 0, 0, time.UTC), s.Start)
+
+	err = rootCmd.ParseFlags([]string{"--timeout", "forever"})
+	assert.NotNil(t, err)
+}
+
+func TestTimeTypesTests(t *testing.T) {
+	s := TimeStruct{Timeout: 2 * time.Hour, Start: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)}
+	_, err := RunTestFlags(&s, nil)
+	assert.Nil(t, err)
+
+	s.Timeout = 25 * time.Hour
+	_, err = RunTestFlags(&s, nil)
+	assert.EqualError(t, err, "field Timeout: value 25h0m0s ! <= 24h0m0s")
+
+	s.Timeout = time.Minute
+	s.Start = time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+	_, err = RunTestFlags(&s, nil)
+	assert.EqualError(t, err, "field Start: value 2023-12-31 00:00:00 +0000 UTC ! >= 2024-01-01T00:00:00Z")
+
+	// a duration operand on a plain number is not allowed
+	_, err = RunTestFlags(&struct {
+		N int `test:">1s"`
+	}{N: 5}, nil)
+	assert.EqualError(t, err, "field N: test operand 1s not a number")
+}
+
+func TestTimeTypesProcess(t *testing.T) {
+	var s TimeStruct
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := NewProcessor(nil).Process(&ConfTagOpts{
+		FlagTagOpts: &FlagFieldSubstOpts{UseFlags: flagset, Args: []string{"-timeout", "36h"}},
+	}, &s)
+	assert.EqualError(t, err, "field Timeout: value 36h0m0s ! <= 24h0m0s")
+}
+
+type logMode int
+
+const (
+	modeQuiet logMode = iota
+	modeVerbose
+)
+
+func (m *logMode) String() string {
+	if m != nil && *m == modeVerbose {
+		return "verbose"
+	}
+	return "quiet"
+}
+
+func (m *logMode) Set(s string) error {
+	switch s {
+	case "quiet":
+		*m = modeQuiet
+	case "verbose":
+		*m = modeVerbose
+	default:
+		return fmt.Errorf("unknown mode %s", s)
+	}
+	return nil
+}
+
+type rgbColor uint32
+
+// newColorProcessor returns a Processor which can parse an rgbColor, i.e. #ff0000
+func newColorProcessor() *Processor {
+	p := NewProcessor(nil)
+	p.RegisterConverter(reflect.TypeOf(rgbColor(0)), func(s string) (interface{}, error) {
+		n, err := strconv.ParseUint(strings.TrimPrefix(s, "#"), 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("not a color")
+		}
+		return rgbColor(n), nil
+	})
+	return p
+}
+
+type ConvStruct struct {
+	IP     net.IP       `env:"CONV_IP" default:"127.0.0.1" flag:"ip"`
+	Proxy  *url.URL     `env:"CONV_PROXY" flag:"proxy"`
+	Subnet netip.Prefix `env:"CONV_SUBNET" default:"10.0.0.0/8" flag:"subnet"`
+	Level  slog.Level   `env:"CONV_LEVEL" default:"info" flag:"level"`
+	Mode   logMode      `env:"CONV_MODE" default:"quiet" flag:"mode"`
+	Color  *rgbColor    `env:"CONV_COLOR" default:"#ff0000" flag:"color"`
+}
+
+func TestConvertedTypesDefaults(t *testing.T) {
+	var s ConvStruct
+	_, err := newColorProcessor().SubsistuteDefaults(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1", s.IP.String())
+	assert.Nil(t, s.Proxy)
+	assert.Equal(t, netip.MustParsePrefix("10.0.0.0/8"), s.Subnet)
+	assert.Equal(t, slog.LevelInfo, s.Level)
+	assert.Equal(t, modeQuiet, s.Mode)
+	assert.Equal(t, rgbColor(0xff0000), *s.Color)
+
+	_, err = SubsistuteDefaults(&struct {
+		IP net.IP `default:"not-an-ip"`
+	}{}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestConvertedTypesEnv(t *testing.T) {
+	var s ConvStruct
+	m := map[string]string{
+		"CONV_IP":     "::1",
+		"CONV_PROXY":  "https://proxy.example.com:3128",
+		"CONV_SUBNET": "192.168.0.0/16",
+		"CONV_LEVEL":  "DEBUG",
+		"CONV_MODE":   "verbose",
+		"CONV_COLOR":  "00ff00",
+	}
+	envopts := &EnvFieldSubstOpts{converters: newColorProcessor().converters}
+	touched, err := EnvFieldSubstitutionFromMap(&s, envopts, m)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"IP", "Proxy", "Subnet", "Level", "Mode", "Color"}, touched)
+	assert.Equal(t, "::1", s.IP.String())
+	assert.Equal(t, "proxy.example.com:3128", s.Proxy.Host)
+	assert.Equal(t, netip.MustParsePrefix("192.168.0.0/16"), s.Subnet)
+	assert.Equal(t, slog.LevelDebug, s.Level)
+	assert.Equal(t, modeVerbose, s.Mode)
+	assert.Equal(t, rgbColor(0x00ff00), *s.Color)
+
+	_, err = EnvFieldSubstitutionFromMap(&s, envopts, map[string]string{"CONV_MODE": "loud"})
+	assert.EqualError(t, err, "field Mode: map (env) CONV_MODE value loud unknown mode loud")
+	_, err = EnvFieldSubstitutionFromMap(&s, envopts, map[string]string{"CONV_COLOR": "red"})
+	assert.EqualError(t, err, "field Color: map (env) CONV_COLOR value red not a color")
+}
+
+func TestConvertedTypesFlags(t *testing.T) {
+	var s ConvStruct
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := newColorProcessor().ProcessFlags(&s, &FlagFieldSubstOpts{
+		UseFlags: flagset,
+		Args:     []string{"-ip", "10.1.2.3", "-proxy", "http://p:80", "-level", "warn", "-mode", "verbose", "-color", "#0000ff"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "10.1.2.3", s.IP.String())
+	assert.Equal(t, "p:80", s.Proxy.Host)
+	assert.Equal(t, slog.LevelWarn, s.Level)
+	assert.Equal(t, modeVerbose, s.Mode)
+	assert.Equal(t, rgbColor(0xff), *s.Color)
+
+	var s2 ConvStruct
+	flagset = flag.NewFlagSet("test", flag.ContinueOnError)
+	err = NewProcessor(nil).ProcessFlags(&s2, &FlagFieldSubstOpts{
+		UseFlags: flagset,
+		Args:     []string{"-subnet", "10.0.0.1"},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestConvertedTypesCobra(t *testing.T) {
+	s := struct {
+		Subnet netip.Prefix `cflag:"subnet" cobra:"root"`
+		Level  slog.Level   `cflag:"level,l" cobra:"root"`
+		Mode   *logMode     `cflag:"mode" cobra:"root"`
+	}{}
+	rootCmd := &cobra.Command{Use: "app"}
+	p := NewProcessor(nil)
+	p.RegisterCobraCmd("root", rootCmd)
+	err := p.PreProcessCobraFlags(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "level", rootCmd.Flags().Lookup("level").Value.Type())
+	err = rootCmd.ParseFlags([]string{"--subnet", "172.16.0.0/12", "-l", "debug", "--mode", "verbose"})
+	assert.Nil(t, err)
+	err = p.PostProcessCobraFlags()
+	assert.Nil(t, err)
+	assert.Equal(t, netip.MustParsePrefix("172.16.0.0/12"), s.Subnet)
+	assert.Equal(t, slog.LevelDebug, s.Level)
+	assert.Equal(t, modeVerbose, *s.Mode)
+}
+
+func TestConvertedTypesProcess(t *testing.T) {
+	var s ConvStruct
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := newColorProcessor().Process(&ConfTagOpts{
+		FlagTagOpts: &FlagFieldSubstOpts{UseFlags: flagset, Args: []string{"-level", "error"}},
+	}, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1", s.IP.String())
+	assert.Equal(t, slog.LevelError, s.Level)
+	assert.Equal(t, rgbColor(0xff0000), *s.Color)
+}
+
+func TestConvertersPerProcessor(t *testing.T) {
+	t.Setenv("CONV_COLOR", "#00ff00")
+	var s ConvStruct
+	err := newColorProcessor().Process(&ConfTagOpts{OrderOfOps: []int{ENVTAGS}}, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, rgbColor(0x00ff00), *s.Color)
+
+	// the converter is only registered with the other Processor
+	s = ConvStruct{}
+	err = NewProcessor(nil).Process(&ConfTagOpts{OrderOfOps: []int{ENVTAGS}}, &s)
+	assert.EqualError(t, err, "field Color: map (env) CONV_COLOR value #00ff00 not a number")
+}
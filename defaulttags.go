@@ -12,100 +12,237 @@ type PostProcessFuncStrings func(defaultval string) string
 type DefaultFieldSubstOpts struct {
 	// throws an error if the environment variable is not found
 	PostProcessDefaultString PostProcessFuncStrings
+	// keep going if a field fails, and return a *MultiError with all the failures
+	CollectErrors bool
+	// the keys present in the config file. If set, fields tagged conf:"zeroisvalid"
+	// whose key is present keep their value even if it is zero. Process fills this in from
+	// the CONFIGFILE stage, or use FindPresentKeys.
+	Present PresentKeys
+	// treat every field as if it had the conf:"zeroisvalid" tag
+	ZeroIsValid bool
+	// if set, only the fields it is true for, by path, get their default. Used by
+	// Process for the defaults it can only apply once the other stages have run.
+	only func(path string, defaultval string) bool
 }
 
 type DefaultFunc func(fieldname string) interface{}
 
-var defaultFuncs map[string]DefaultFunc
-
+// RegisterDefaultFunc registers a DefaultFunc with the default Processor
 func RegisterDefaultFunc(id string, f DefaultFunc) map[string]DefaultFunc {
-	if defaultFuncs == nil {
-		defaultFuncs = make(map[string]DefaultFunc)
-	}
-	defaultFuncs[id] = f
-	return defaultFuncs
+	return defaultProcessor.RegisterDefaultFunc(id, f)
+}
+
+// RegisterDefaultFunc registers a DefaultFunc which can be referenced by a
+// default:"$(id)" tag
+func (p *Processor) RegisterDefaultFunc(id string, f DefaultFunc) map[string]DefaultFunc {
+	p.defaultFuncs[id] = f
+	return p.defaultFuncs
 }
 
 var matchDefaultFuncPat = `^\s*\$\(([a-z,A-Z,_]+[a-z,A-Z,0-9,\_]*)\)\s*$`
 
 var matchDefaultFuncRE = regexp.MustCompile(matchDefaultFuncPat)
 
-// EnvFieldSubstitutionFromMap is a function that takes a pointer to a struct
+// SubsistuteDefaults replaces zero values in the struct with the value of their default: tag
+// using the default Processor
 func SubsistuteDefaults(somestruct interface{}, opts *DefaultFieldSubstOpts) (ret []string, err error) {
+	return defaultProcessor.SubsistuteDefaults(somestruct, opts)
+}
 
-	var innerSubst func(parentpath string, somestruct interface{}) (err error)
+// SubsistuteDefaults replaces zero values in the struct with the value of their default: tag
+func (p *Processor) SubsistuteDefaults(somestruct interface{}, opts *DefaultFieldSubstOpts) (ret []string, err error) {
+	conv := p.converters
+	var collect bool
+	if opts != nil {
+		collect = opts.CollectErrors
+	}
+	errs := newErrorCollector(STAGEDEFAULT, collect, somestruct)
 
-	setDefaultSlice := func(sliceValue reflect.Value, defaultval string) error {
-		parsedVals := strings.Split(defaultval, ",")
-		k := sliceValue.Type().Elem().Kind()
-		switch k {
-		case reflect.Ptr:
-			// TODO add support for Ptr to Structs
-		case reflect.String:
-			for _, parsedVal := range parsedVals {
-				// Change the value of the field to the tag value
-				if opts != nil && opts.PostProcessDefaultString != nil {
-					parsedVal = opts.PostProcessDefaultString(parsedVal)
-				}
-				sliceValue.Set(reflect.Append(sliceValue, reflect.ValueOf(parsedVal)))
+	// keepZero is true if the field's key was in the config file and zero is a
+	// valid value for it, so no default should be applied
+	keepZero := func(path string, field reflect.StructField, confops map[string]string) bool {
+		if opts == nil || opts.Present == nil || errs.root == nil {
+			return false
+		}
+		if !opts.ZeroIsValid && !zeroIsValid(confops) {
+			return false
+		}
+		return conv.isLeafType(field.Type) && opts.Present.Has(keyPath(errs.root, path))
+	}
+
+	root := reflect.ValueOf(somestruct)
+	// the fields whose default is being worked out, to find a default which refers back to itself
+	var resolving []string
+	var expandRefs func(path string, defaultval string) (string, error)
+
+	// storeDefault sets the field at path, which is v, to the default worked out for it
+	// by fieldString, so the default is only worked out once. fresult is the result of
+	// its default func, if it has one. Only fields holding a single value are set.
+	storeDefault := func(path string, v reflect.Value, field reflect.StructField, fresult reflect.Value, s string) {
+		t := v.Type()
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if !v.CanSet() || !conv.isScalarType(t) {
+			return
+		}
+		if fresult.IsValid() && fresult.Type() == v.Type() {
+			v.Set(fresult)
+			ret = append(ret, path)
+			return
+		}
+		val := fresult
+		if !val.IsValid() || val.Type() != t {
+			var err error
+			val, err = conv.convertScalar(t, s, field.Tag.Get(LAYOUTFIELD))
+			if err != nil {
+				// the field's own turn reports it
+				return
 			}
-			// TODO add float
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			for _, parsedVal := range parsedVals {
-				val, err := StringToInt64(parsedVal)
-				if err != nil {
-					return fmt.Errorf("default value %s not a number", defaultval)
-				}
-				// Change the value of the field to the tag value
-				if reflect.ValueOf(val).CanConvert(sliceValue.Type().Elem()) {
-					sliceValue.Set(reflect.Append(sliceValue, reflect.ValueOf(val).Convert(sliceValue.Type().Elem())))
-				} else {
-					return fmt.Errorf("default value %s has number out of range for %s", defaultval, sliceValue.Type().Elem().String())
-				}
+		}
+		if v.Kind() == reflect.Ptr {
+			ptr := reflect.New(t)
+			ptr.Elem().Set(val)
+			val = ptr
+		}
+		v.Set(val)
+		ret = append(ret, path)
+	}
+
+	// fieldString returns the value the field at path has once its default is applied, as a string
+	fieldString := func(path string) (string, error) {
+		v, field, ok := conv.lookupFieldPath(root, path)
+		if !ok {
+			return "", fmt.Errorf("%s is not a field", path)
+		}
+		if !isZeroOrNil(v) {
+			return conv.formatValue(v), nil
+		}
+		dflt := field.Tag.Get("default")
+		confops := processConfTagOptsValues(field.Tag.Get(CONFFIELD))
+		if len(dflt) < 1 || skipField(confops) || defaultSkip(confops) || keepZero(path, field, confops) {
+			return conv.formatValue(v), nil
+		}
+		// the field does not get its default now, so it is used as it is
+		if opts != nil && opts.only != nil && !opts.only(path, dflt) {
+			return conv.formatValue(v), nil
+		}
+		if matches := matchDefaultFuncRE.FindStringSubmatch(dflt); len(matches) > 1 {
+			if f := p.defaultFuncs[matches[1]]; f != nil {
+				fresult := reflect.ValueOf(f(field.Name))
+				val := conv.formatValue(fresult)
+				storeDefault(path, v, field, fresult, val)
+				return val, nil
 			}
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			for _, parsedVal := range parsedVals {
-				val, err := StringToUint64(parsedVal)
-				if err != nil {
-					return fmt.Errorf("default value %s not a number", defaultval)
-				}
-				// Change the value of the field to the tag value
-				if reflect.ValueOf(val).CanConvert(sliceValue.Type().Elem()) {
-					sliceValue.Set(reflect.Append(sliceValue, reflect.ValueOf(val).Convert(sliceValue.Type().Elem())))
-				} else {
-					return fmt.Errorf("default value %s has number out of range for %s", defaultval, sliceValue.Type().Elem().String())
-				}
+			return conv.formatValue(v), nil
+		}
+		val, err := expandRefs(path, dflt)
+		if err != nil {
+			return "", err
+		}
+		if derefOrZero(v).Kind() == reflect.String && opts != nil && opts.PostProcessDefaultString != nil {
+			val = opts.PostProcessDefaultString(val)
+		}
+		storeDefault(path, v, field, reflect.Value{}, val)
+		return val, nil
+	}
+
+	// expandRefs replaces the references to other fields in the default of the field at path,
+	// i.e. ${.Host} or ${/SSL.Port+1}, with their values. Any of those which are still zero
+	// get the value of their own default, so it does not matter which is set first.
+	expandRefs = func(path string, defaultval string) (string, error) {
+		if !hasFieldRefs(defaultval) {
+			return defaultval, nil
+		}
+		for n, r := range resolving {
+			if r == path {
+				return "", fmt.Errorf("default refers back to itself: %s", strings.Join(append(resolving[n:], path), " -> "))
 			}
-		case reflect.Float64, reflect.Float32:
-			for _, parsedVal := range parsedVals {
-				val, err := StringToFloat64(parsedVal)
-				if err != nil {
-					return fmt.Errorf("default value %s not a number", defaultval)
-				}
-				// Change the value of the field to the tag value
-				if reflect.ValueOf(val).CanConvert(sliceValue.Type().Elem()) {
-					sliceValue.Set(reflect.Append(sliceValue, reflect.ValueOf(val).Convert(sliceValue.Type().Elem())))
-				} else {
-					return fmt.Errorf("default value %s has number out of range for %s", defaultval, sliceValue.Type().Elem().String())
-				}
+		}
+		resolving = append(resolving, path)
+		defer func() { resolving = resolving[:len(resolving)-1] }()
+		var err error
+		val := fieldRefRE.ReplaceAllStringFunc(defaultval, func(ref string) string {
+			if err != nil {
+				return ""
 			}
-		default:
-			debugf("default: default for %s underlying type unsupported (setDefault)", k.String())
+			m := fieldRefRE.FindStringSubmatch(ref)
+			target := m[2]
+			if m[1] == "." {
+				target = addParentPath(parentPath(path), m[2])
+			}
+			var s string
+			s, err = fieldString(target)
+			if err == nil && len(m[3]) > 0 {
+				s, err = addToNumber(s, m[3], m[4])
+			}
+			return s
+		})
+		return val, err
+	}
+
+	var innerSubst func(parentpath string, somestruct interface{}) (err error)
+
+	// setDefaultConverted sets a zero field of a type parsed by convertString, i.e. time.Duration.
+	// A default func must return a value of the field's type.
+	setDefaultConverted := func(parentpath string, fieldName string, fieldValue reflect.Value, defaultval string, layout string, f DefaultFunc, funcname string) error {
+		if !fieldValue.IsZero() {
+			return nil
+		}
+		if f != nil {
+			v := reflect.ValueOf(f(fieldName))
+			if !v.IsValid() || v.Type() != fieldValue.Type() {
+				return fmt.Errorf("default func %s did not return a %s", funcname, fieldValue.Type().String())
+			}
+			fieldValue.Set(v)
+		} else {
+			v, err := conv.convertString(fieldValue.Type(), defaultval, layout)
+			if err != nil {
+				return fmt.Errorf("default value %s %s", defaultval, err.Error())
+			}
+			fieldValue.Set(v)
+		}
+		ret = append(ret, addParentPath(parentpath, fieldName))
+		return nil
+	}
+
+	setDefaultSlice := func(sliceValue reflect.Value, defaultval string, sep string, layout string) error {
+		parsedVals := splitList(defaultval, sep)
+		if !conv.isScalarType(sliceValue.Type().Elem()) {
+			// TODO add support for Ptr to Structs
+			debugf("default: default for %s underlying type unsupported (setDefault)", sliceValue.Type().Elem().String())
+			return nil
+		}
+		if sliceValue.Type().Elem().Kind() == reflect.String && opts != nil && opts.PostProcessDefaultString != nil {
+			for n := range parsedVals {
+				parsedVals[n] = opts.PostProcessDefaultString(parsedVals[n])
+			}
+		}
+		err := conv.appendSliceElems(sliceValue, parsedVals, layout)
+		if err != nil {
+			return fmt.Errorf("default value %s %s", defaultval, err.Error())
 		}
 		return nil
 	}
 
-	setDefault := func(parentpath string, fieldName string, fieldValue reflect.Value, defaultval string) error {
+	setDefault := func(parentpath string, fieldName string, fieldValue reflect.Value, defaultval string, layout string) error {
 		var f DefaultFunc
 		matches := matchDefaultFuncRE.FindAllStringSubmatch(defaultval, -1)
 		if len(matches) > 0 {
 			if len(matches[0]) > 1 {
 				debugf("default: Found a default func (setDefault): %s\n", matches[0][1])
-				f = defaultFuncs[matches[0][1]]
+				f = p.defaultFuncs[matches[0][1]]
 			}
 		} else {
 			debugf("default: No default func found for %s\n", fieldName)
 		}
+		if conv.isConvertedType(fieldValue.Type()) {
+			var funcname string
+			if f != nil {
+				funcname = matches[0][1]
+			}
+			return setDefaultConverted(parentpath, fieldName, fieldValue, defaultval, layout, f, funcname)
+		}
 
 		k := fieldValue.Kind()
 		switch k {
@@ -202,17 +339,24 @@ func SubsistuteDefaults(somestruct interface{}, opts *DefaultFieldSubstOpts) (re
 		return nil
 	}
 
-	setDefaultPtr := func(parentpath string, fieldName string, fieldValue reflect.Value, defaultval string) error {
+	setDefaultPtr := func(parentpath string, fieldName string, fieldValue reflect.Value, defaultval string, layout string) error {
 		var f DefaultFunc
 		matches := matchDefaultFuncRE.FindAllStringSubmatch(defaultval, -1)
 		if len(matches) > 0 {
 			if len(matches[0]) > 1 {
 				debugf("default: Found a default func (setDefaultPtr): %s\n", matches[0][1])
-				f = defaultFuncs[matches[0][1]]
+				f = p.defaultFuncs[matches[0][1]]
 			}
 		} else {
 			debugf("default (ptr): No default func found for %s\n", fieldName)
 		}
+		if conv.isConvertedType(fieldValue.Elem().Type()) {
+			var funcname string
+			if f != nil {
+				funcname = matches[0][1]
+			}
+			return setDefaultConverted(parentpath, fieldName, fieldValue.Elem(), defaultval, layout, f, funcname)
+		}
 
 		k := fieldValue.Elem().Kind()
 		switch k {
@@ -304,6 +448,34 @@ func SubsistuteDefaults(somestruct interface{}, opts *DefaultFieldSubstOpts) (re
 		return nil
 	}
 
+	// setDefaultMap fills an empty map from a list of key=value pairs, i.e. default:"a=1,b=2",
+	// or from a default func which returns a map of the field's type
+	setDefaultMap := func(parentpath string, fieldName string, fieldValue reflect.Value, defaultval string, sep string, layout string) error {
+		matches := matchDefaultFuncRE.FindStringSubmatch(defaultval)
+		if len(matches) > 1 {
+			f := p.defaultFuncs[matches[1]]
+			if f == nil {
+				return fmt.Errorf("default func %s not found", matches[1])
+			}
+			v := reflect.ValueOf(f(fieldName))
+			if !v.IsValid() || v.Type() != fieldValue.Type() {
+				return fmt.Errorf("default func %s did not return a %s", matches[1], fieldValue.Type().String())
+			}
+			fieldValue.Set(v)
+		} else {
+			if conv.isMapOfStructs(fieldValue.Type()) {
+				return fmt.Errorf("default for %s needs a default func", fieldValue.Type().String())
+			}
+			m, err := conv.parseMap(fieldValue.Type(), defaultval, sep, layout)
+			if err != nil {
+				return fmt.Errorf("default value %s %s", defaultval, err.Error())
+			}
+			fieldValue.Set(m)
+		}
+		ret = append(ret, addParentPath(parentpath, fieldName))
+		return nil
+	}
+
 	innerSubst = func(parentpath string, somestruct interface{}) (err error) {
 		// Get the value of the input. This will be a reflect.Value
 		valuePtr := reflect.ValueOf(somestruct)
@@ -343,12 +515,29 @@ func SubsistuteDefaults(somestruct interface{}, opts *DefaultFieldSubstOpts) (re
 				debugf("default: Field %s is not exported\n", field.Name)
 				continue
 			}
+			if keepZero(addParentPath(parentpath, field.Name), field, confops) {
+				debugf("default: Field %s is in the config file, not using default\n", field.Name)
+				continue
+			}
+			if opts != nil && opts.only != nil && len(defaultval) > 0 && conv.isLeafType(field.Type) && !opts.only(addParentPath(parentpath, field.Name), defaultval) {
+				continue
+			}
 			debugf("default: Field Name: %s, Default val: %s\n", field.Name, defaultval)
 			// if len(defaultval) > 0 {
 			// Get the field value
 			fieldValue := inputValue.FieldByName(field.Name)
+			if hasFieldRefs(defaultval) && isZeroOrNil(fieldValue) {
+				defaultval, err = expandRefs(addParentPath(parentpath, field.Name), defaultval)
+				if err != nil {
+					err = errs.add(addParentPath(parentpath, field.Name), field.Tag.Get("default"), nil, err)
+					if err != nil {
+						return
+					}
+					continue
+				}
+			}
 			// Only do substitution if the field value can be changed
-			if field.Type.Kind() == reflect.Ptr || field.Type.Kind() == reflect.Slice {
+			if (field.Type.Kind() == reflect.Ptr || field.Type.Kind() == reflect.Slice) && !conv.isConvertedType(field.Type) {
 				// recurse
 				fieldValue := inputValue.FieldByName(field.Name)
 				// used to create a temp Value of any kind, based on the underlying
@@ -369,7 +558,7 @@ func SubsistuteDefaults(somestruct interface{}, opts *DefaultFieldSubstOpts) (re
 					matches := matchDefaultFuncRE.FindAllStringSubmatch(defaultval, -1)
 					if len(matches) > 0 {
 						if len(matches[0]) > 1 {
-							f = defaultFuncs[matches[0][1]]
+							f = p.defaultFuncs[matches[0][1]]
 							debugf("Found a default func (if Ptr nil): %s %p\n", matches[0][1], f)
 						}
 					}
@@ -384,74 +573,85 @@ func SubsistuteDefaults(somestruct interface{}, opts *DefaultFieldSubstOpts) (re
 					// 	if reflect.TypeOf(v) == t.Elem() {
 					// 	fieldValue.Set(reflect.ValueOf(v))
 					// } else {
-					switch t.Elem().Kind() {
-					case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-						if field.Type.Kind() == reflect.Ptr {
-							debugf("default: Ptr: Underlying fundamental type: %s\n", t.Elem().Kind().String())
-							if f != nil {
-								if fresultType.Kind() == reflect.Ptr && fresultType.Elem().Kind() == t.Elem().Kind() {
-									if fresultType.Elem().Kind() == reflect.String {
-										if opts != nil && opts.PostProcessDefaultString != nil {
-											fresult = opts.PostProcessDefaultString(fresult.(string))
+					if conv.isConvertedType(t.Elem()) || conv.isScalarSliceType(field.Type) {
+						// i.e. a *time.Time or []float64, which is created like a fundamental type
+						if len(defaultval) > 0 {
+							if field.Type.Kind() == reflect.Ptr {
+								fieldValue.Set(reflect.New(t.Elem()))
+							} else {
+								fieldValue.Set(reflect.MakeSlice(fieldValue.Type(), 0, 0))
+							}
+						}
+					} else {
+						switch t.Elem().Kind() {
+						case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+							if field.Type.Kind() == reflect.Ptr {
+								debugf("default: Ptr: Underlying fundamental type: %s\n", t.Elem().Kind().String())
+								if f != nil {
+									if fresultType.Kind() == reflect.Ptr && fresultType.Elem().Kind() == t.Elem().Kind() {
+										if fresultType.Elem().Kind() == reflect.String {
+											if opts != nil && opts.PostProcessDefaultString != nil {
+												fresult = opts.PostProcessDefaultString(fresult.(string))
+											}
 										}
+										fieldValue.Set(reflect.ValueOf(fresult))
+										ret = append(ret, addParentPath(parentpath, field.Name))
+										continue
+									} else {
+										return fmt.Errorf("default func %s did not return a Ptr of the correct type: ", matches[0][1])
+									}
+								} else {
+									if len(defaultval) > 0 {
+										fieldValue.Set(reflect.New(t.Elem()))
 									}
+								}
+							}
+							if field.Type.Kind() == reflect.Slice {
+								if len(defaultval) > 0 {
+									debugf("default: Slice: Underlying fundamental type: %s\n", t.Elem().Kind().String())
+									fieldValue.Set(reflect.MakeSlice(fieldValue.Type(), 0, 0))
+								}
+							}
+						case reflect.Struct:
+							debugf("Ptr: Underlying struct type: %s\n", t.Elem().Kind().String())
+							if f != nil {
+								if fresultType.Kind() == reflect.Ptr && fieldValue.Type().Elem() == fresultType.Elem() {
+									debugf("default: Ptr: Func: Underlying struct type: %s\n", t.Elem().String())
 									fieldValue.Set(reflect.ValueOf(fresult))
 									ret = append(ret, addParentPath(parentpath, field.Name))
 									continue
 								} else {
-									return fmt.Errorf("default func %s did not return a Ptr of the correct type: ", matches[0][1])
+									return fmt.Errorf("default func %s did not return a ptr to struct of the correct type: ", matches[0][1])
 								}
 							} else {
-								if len(defaultval) > 0 {
-									fieldValue.Set(reflect.New(t.Elem()))
+								// no function? ok - then if its a Ptr to a struct, we create it
+								// otherwise we ignore it
+								if field.Type.Kind() == reflect.Ptr {
+									if fieldValue.CanSet() {
+										fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
+									} else {
+										debugf("default: Field %s cannot be set (private ?)\n", field.Name)
+									}
 								}
 							}
-						}
-						if field.Type.Kind() == reflect.Slice {
-							if len(defaultval) > 0 {
-								debugf("default: Slice: Underlying fundamental type: %s\n", t.Elem().Kind().String())
-								fieldValue.Set(reflect.MakeSlice(fieldValue.Type(), 0, 0))
-							}
-						}
-					case reflect.Struct:
-						debugf("Ptr: Underlying struct type: %s\n", t.Elem().Kind().String())
-						if f != nil {
-							if fresultType.Kind() == reflect.Ptr && fieldValue.Type().Elem() == fresultType.Elem() {
-								debugf("default: Ptr: Func: Underlying struct type: %s\n", t.Elem().String())
+						case reflect.Slice:
+							debugf("default: Slice: Underlying slice type: %s\n", t.Elem().Kind().String())
+							if fresultType.Kind() == reflect.Slice && fieldValue.Type().Elem() == fresultType.Elem() {
+								debugf("default: Slice: Func: Underlying struct type: %s\n", t.Elem().String())
 								fieldValue.Set(reflect.ValueOf(fresult))
 								ret = append(ret, addParentPath(parentpath, field.Name))
 								continue
 							} else {
-								return fmt.Errorf("default func %s did not return a ptr to struct of the correct type: ", matches[0][1])
-							}
-						} else {
-							// no function? ok - then if its a Ptr to a struct, we create it
-							// otherwise we ignore it
-							if field.Type.Kind() == reflect.Ptr {
-								if fieldValue.CanSet() {
-									fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
-								} else {
-									debugf("default: Field %s cannot be set (private ?)\n", field.Name)
-								}
+								fieldValue.Set(reflect.MakeSlice(fieldValue.Type().Elem(), 0, 0))
 							}
-						}
-					case reflect.Slice:
-						debugf("default: Slice: Underlying slice type: %s\n", t.Elem().Kind().String())
-						if fresultType.Kind() == reflect.Slice && fieldValue.Type().Elem() == fresultType.Elem() {
-							debugf("default: Slice: Func: Underlying struct type: %s\n", t.Elem().String())
-							fieldValue.Set(reflect.ValueOf(fresult))
-							ret = append(ret, addParentPath(parentpath, field.Name))
+						default:
+							debugf("default: ignoring: default for %s underlying type unsupported\n", field.Name)
 							continue
-						} else {
-							fieldValue.Set(reflect.MakeSlice(fieldValue.Type().Elem(), 0, 0))
+							// default:
+							// 	debugf("Got a NON-fundamental type: %s %s which is a %s\n", t.Kind().String(), t.Elem().String(), t.Elem().Kind().String())
+							// 	switch t.Elem().Kind() {
+							// 	}
 						}
-					default:
-						debugf("default: ignoring: default for %s underlying type unsupported\n", field.Name)
-						continue
-						// default:
-						// 	debugf("Got a NON-fundamental type: %s %s which is a %s\n", t.Kind().String(), t.Elem().String(), t.Elem().Kind().String())
-						// 	switch t.Elem().Kind() {
-						// 	}
 					}
 					// }
 				}
@@ -461,14 +661,18 @@ func SubsistuteDefaults(somestruct interface{}, opts *DefaultFieldSubstOpts) (re
 					// TODO - add support for Slice here
 					if field.Type.Kind() == reflect.Slice {
 						if fieldValue.Len() < 1 {
-							err = setDefaultSlice(fieldValue, defaultval)
+							err = setDefaultSlice(fieldValue, defaultval, listSep(confops), field.Tag.Get(LAYOUTFIELD))
 							if err != nil {
-								return
+								err = errs.add(addParentPath(parentpath, field.Name), defaultval, defaultval, err)
+								if err != nil {
+									return
+								}
+								continue
 							}
 							ret = append(ret, addParentPath(parentpath, field.Name))
 						} else {
 
-							if field.Type.Elem().Kind() == reflect.Ptr && field.Type.Elem().Elem().Kind() == reflect.Struct {
+							if field.Type.Elem().Kind() == reflect.Ptr && conv.isStructType(field.Type.Elem().Elem()) {
 								for n := 0; n < fieldValue.Len(); n++ {
 									name := fmt.Sprintf("%s[%d]", field.Name, n)
 									debugf("default: slice of struct ptr %s\n", name)
@@ -477,7 +681,7 @@ func SubsistuteDefaults(somestruct interface{}, opts *DefaultFieldSubstOpts) (re
 										return err
 									}
 								}
-							} else if field.Type.Elem().Kind() == reflect.Struct {
+							} else if conv.isStructType(field.Type.Elem()) {
 								for n := 0; n < fieldValue.Len(); n++ {
 									name := fmt.Sprintf("%s[%d]", field.Name, n)
 									debugf("default: slice of struct %s\n", name)
@@ -492,7 +696,7 @@ func SubsistuteDefaults(somestruct interface{}, opts *DefaultFieldSubstOpts) (re
 					} else
 
 					// is this a Ptr to a struct?
-					if t.Elem().Kind() == reflect.Struct {
+					if conv.isStructType(t.Elem()) {
 						err := innerSubst(addParentPath(parentpath, field.Name), fieldValue.Elem().Addr().Interface())
 						if err != nil {
 							return err
@@ -500,14 +704,27 @@ func SubsistuteDefaults(somestruct interface{}, opts *DefaultFieldSubstOpts) (re
 					} else {
 						// nope then its just a fundamental type
 						if len(defaultval) > 0 {
-							err = setDefaultPtr(parentpath, field.Name, fieldValue, defaultval)
+							err = errs.add(addParentPath(parentpath, field.Name), defaultval, defaultval, setDefaultPtr(parentpath, field.Name, fieldValue, defaultval, field.Tag.Get(LAYOUTFIELD)))
 							if err != nil {
 								return
 							}
 						}
 					}
 				}
-			} else if field.Type.Kind() == reflect.Struct {
+			} else if conv.isMapType(field.Type) {
+				if fieldValue.Len() < 1 && len(defaultval) > 0 {
+					err = errs.add(addParentPath(parentpath, field.Name), defaultval, defaultval, setDefaultMap(parentpath, field.Name, fieldValue, defaultval, listSep(confops), field.Tag.Get(LAYOUTFIELD)))
+					if err != nil {
+						return
+					}
+				}
+				if conv.isMapOfStructs(field.Type) && !fieldValue.IsNil() {
+					err := walkMapStructs(addParentPath(parentpath, field.Name), fieldValue, innerSubst)
+					if err != nil {
+						return err
+					}
+				}
+			} else if conv.isStructType(field.Type) {
 				// recurse
 				fieldValue := inputValue.FieldByName(field.Name)
 				// is this a Ptr to a struct?
@@ -515,13 +732,13 @@ func SubsistuteDefaults(somestruct interface{}, opts *DefaultFieldSubstOpts) (re
 				if err != nil {
 					return err
 				}
-			} else if field.Type.Kind() == reflect.Slice {
+			} else if field.Type.Kind() == reflect.Slice && !conv.isConvertedType(field.Type) {
 				// recurse
 				debugf("default: FIXME 2\n")
 
 			} else if fieldValue.CanSet() {
 				if len(defaultval) > 0 {
-					err = setDefault(parentpath, field.Name, fieldValue, defaultval)
+					err = errs.add(addParentPath(parentpath, field.Name), defaultval, defaultval, setDefault(parentpath, field.Name, fieldValue, defaultval, field.Tag.Get(LAYOUTFIELD)))
 					if err != nil {
 						return
 					}
@@ -539,5 +756,5 @@ func SubsistuteDefaults(somestruct interface{}, opts *DefaultFieldSubstOpts) (re
 	}
 
 	err = innerSubst("", somestruct)
-	return ret, err
+	return ret, errs.result(err)
 }
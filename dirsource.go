@@ -0,0 +1,134 @@
+package conftagz
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// KEYFIELD is the tag naming the file in a DIRECTORY stage directory which holds
+// the value of the field, i.e. key:"log-level"
+const KEYFIELD = "key"
+
+// K8SDATADIR is the symlink Kubernetes points at the current copy of the files of a
+// ConfigMap, Secret or downward API volume. It is switched atomically on an update.
+const K8SDATADIR = "..data"
+
+// DirFieldSubstOpts are the options for the DIRECTORY stage, which sets fields from
+// a directory with one file per key, i.e. a mounted Kubernetes ConfigMap
+type DirFieldSubstOpts struct {
+	// the directory to read
+	Dir string
+	// if true, a Dir which does not exist is not an error
+	IgnoreMissing bool
+	// the largest file read. DEFAULTMAXFILESIZE if not set.
+	MaxFileSize int64
+	// keep going if a field fails, and return a *MultiError with all the failures
+	CollectErrors bool
+	// if not nil, filled in with the file used for each field that had one, by
+	// the path of the field
+	UsedNames map[string]string
+	// the converters of the Processor running the stage. See EnvFieldSubstOpts
+	converters converterMap
+}
+
+// DirToMap reads every file in dir into a map of file name to contents, with
+// trailing newlines removed. Hidden files and directories are left out, as are
+// files which can not be read or are larger than maxsize. If dir holds the
+// K8SDATADIR symlink, the files are all read from where it points, so they come
+// from the same update.
+func DirToMap(dir string, maxsize int64) (map[string]string, error) {
+	m, _, err := dirToMap(dir, maxsize)
+	return m, err
+}
+
+// dirToMap is DirToMap, which also returns the error for each file which could not
+// be read. Those are only a problem if a field uses them.
+func dirToMap(dir string, maxsize int64) (m map[string]string, failed map[string]error, err error) {
+	if data, err := filepath.EvalSymlinks(filepath.Join(dir, K8SDATADIR)); err == nil {
+		dir = data
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	m = make(map[string]string)
+	failed = make(map[string]error)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		// Stat rather than the entry, to follow symlinks
+		info, err := os.Stat(path)
+		if err != nil {
+			debugf("dir: skipping %s: %s\n", path, err.Error())
+			failed[entry.Name()] = err
+			continue
+		}
+		if info.IsDir() {
+			continue
+		}
+		val, err := readValueFile(path, maxsize, false)
+		if err != nil {
+			debugf("dir: skipping %s: %s\n", path, err.Error())
+			failed[entry.Name()] = err
+			continue
+		}
+		m[entry.Name()] = val
+	}
+	return m, failed, nil
+}
+
+// dirReadError returns the first of keys which is a file that could not be read,
+// and the error reading it, unless a file before it was read
+func dirReadError(keys []envAlias, m map[string]string, failed map[string]error) (string, error) {
+	for _, key := range keys {
+		if _, ok := m[key.name]; ok {
+			return "", nil
+		}
+		if err, ok := failed[key.name]; ok {
+			return key.name, err
+		}
+	}
+	return "", nil
+}
+
+// dirKeys returns the file names which can hold the value of a field, in order:
+// its key: tag, its yaml (or json) key path, then its env var names
+func (c converterMap) dirKeys(root reflect.Type, path string, field reflect.StructField, envnames []envAlias) (keys []envAlias) {
+	key := field.Tag.Get(KEYFIELD)
+	if key == "-" || !c.isLeafType(field.Type) {
+		return nil
+	}
+	if len(key) > 0 {
+		keys = append(keys, envAlias{name: key})
+	}
+	if root != nil {
+		keys = append(keys, envAlias{name: keyPath(root, path)})
+	}
+	for _, name := range envnames {
+		keys = append(keys, envAlias{name: name.name})
+	}
+	return
+}
+
+// DirFieldSubstitution sets the fields of the struct from the files in opts.Dir. A file
+// is used for a field if its name is the key: tag of the field, its yaml key (i.e.
+// sslstuff.cert) or one of its env var names. The value is converted just as
+// an env var would be. It returns the paths of the fields which were set.
+func DirFieldSubstitution(somestruct interface{}, opts *DirFieldSubstOpts) (ret []string, err error) {
+	if opts == nil || len(opts.Dir) < 1 {
+		return nil, fmt.Errorf("no directory given")
+	}
+	m, failed, err := dirToMap(opts.Dir, opts.MaxFileSize)
+	if err != nil {
+		if opts.IgnoreMissing && os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return EnvFieldSubstitutionFromMap(somestruct, &EnvFieldSubstOpts{CollectErrors: opts.CollectErrors, UsedNames: opts.UsedNames, fromDir: true, dirFailed: failed, converters: opts.converters}, m)
+}
@@ -0,0 +1,110 @@
+package conftagz
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type DirSSL struct {
+	Cert string `yaml:"cert"`
+}
+
+type DirStruct struct {
+	Port     int               `yaml:"port"`
+	LogLevel string            `yaml:"log_level" key:"log-level"`
+	Region   string            `yaml:"region" env:"APP_REGION"`
+	Labels   map[string]string `yaml:"labels"`
+	Secret   string            `yaml:"secret" key:"-"`
+	SSL      *DirSSL           `yaml:"sslstuff"`
+}
+
+// writeConfigMap lays out files the way Kubernetes mounts a ConfigMap: the files
+// are in a timestamped directory, ..data points at it, and each key is a
+// symlink through ..data
+func writeConfigMap(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "..2024_01_01_00_00_00.000000001")
+	assert.Nil(t, os.Mkdir(data, 0o755))
+	for name, content := range files {
+		assert.Nil(t, os.WriteFile(filepath.Join(data, name), []byte(content), 0o644))
+		assert.Nil(t, os.Symlink(filepath.Join(K8SDATADIR, name), filepath.Join(dir, name)))
+	}
+	assert.Nil(t, os.Symlink(filepath.Base(data), filepath.Join(dir, K8SDATADIR)))
+	return dir
+}
+
+func TestDirFieldSubstitution(t *testing.T) {
+	dir := writeConfigMap(t, map[string]string{
+		"port":          "8080\n",
+		"log-level":     "debug",
+		"log_level":     "ignored",
+		"APP_REGION":    "eu",
+		"labels":        "tier=web,team=core",
+		"secret":        "not used",
+		"sslstuff.cert": "cert.pem",
+	})
+	var s DirStruct
+	used := map[string]string{}
+	_, err := DirFieldSubstitution(&s, &DirFieldSubstOpts{Dir: dir, UsedNames: used})
+	assert.Nil(t, err)
+	assert.Equal(t, 8080, s.Port)
+	assert.Equal(t, "debug", s.LogLevel)
+	assert.Equal(t, "eu", s.Region)
+	assert.Equal(t, map[string]string{"tier": "web", "team": "core"}, s.Labels)
+	assert.Equal(t, "", s.Secret)
+	assert.Equal(t, "cert.pem", s.SSL.Cert)
+	assert.Equal(t, "log-level", used["LogLevel"])
+}
+
+func TestDirFieldSubstitutionErrors(t *testing.T) {
+	var s DirStruct
+	_, err := DirFieldSubstitution(&s, nil)
+	assert.EqualError(t, err, "no directory given")
+	_, err = DirFieldSubstitution(&s, &DirFieldSubstOpts{IgnoreMissing: true})
+	assert.EqualError(t, err, "no directory given")
+
+	_, err = DirFieldSubstitution(&s, &DirFieldSubstOpts{Dir: filepath.Join(t.TempDir(), "nope")})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = DirFieldSubstitution(&s, &DirFieldSubstOpts{Dir: filepath.Join(t.TempDir(), "nope"), IgnoreMissing: true})
+	assert.Nil(t, err)
+
+	dir := writeConfigMap(t, map[string]string{"port": "eighty"})
+	_, err = DirFieldSubstitution(&s, &DirFieldSubstOpts{Dir: dir})
+	var fe *FieldError
+	assert.ErrorAs(t, err, &fe)
+	assert.Equal(t, STAGEDIR, fe.Stage)
+	assert.Equal(t, "Port", fe.Path)
+
+	// a file which is too large is only an error if a field uses it
+	dir = writeConfigMap(t, map[string]string{"port": "8080", "unused": "a value which is too long"})
+	s = DirStruct{}
+	_, err = DirFieldSubstitution(&s, &DirFieldSubstOpts{Dir: dir, MaxFileSize: 8})
+	assert.Nil(t, err)
+	assert.Equal(t, 8080, s.Port)
+
+	dir = writeConfigMap(t, map[string]string{"log-level": "a value which is too long", "log_level": "debug"})
+	s = DirStruct{}
+	_, err = DirFieldSubstitution(&s, &DirFieldSubstOpts{Dir: dir, MaxFileSize: 8})
+	assert.ErrorAs(t, err, &fe)
+	assert.Equal(t, "LogLevel", fe.Path)
+	assert.Equal(t, "log-level", fe.Tag)
+	assert.ErrorContains(t, err, "is larger than 8 bytes")
+	assert.Equal(t, "", s.LogLevel)
+}
+
+func TestProcessDirectory(t *testing.T) {
+	dir := writeConfigMap(t, map[string]string{"port": "8080", "APP_REGION": "eu"})
+	t.Setenv("APP_REGION", "us")
+	var s DirStruct
+	prov := Provenance{}
+	err := NewProcessor(nil).Process(&ConfTagOpts{DirOpts: &DirFieldSubstOpts{Dir: dir}, Provenance: prov}, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, 8080, s.Port)
+	assert.Equal(t, "us", s.Region)
+	assert.Equal(t, STAGEDIR, prov["Port"].Stage)
+	assert.Equal(t, "port", prov["Port"].Key)
+	assert.Equal(t, STAGEDIR, prov["Region"].Overrode[0].Stage)
+}
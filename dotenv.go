@@ -0,0 +1,268 @@
+package conftagz
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// dotenvParser reads the entries of a .env file. line is the line number at pos,
+// for errors.
+type dotenvParser struct {
+	s    string
+	pos  int
+	line int
+	// looks up ${VAR}: the entries so far, then the env
+	lookup func(name string) (string, bool)
+}
+
+func (p *dotenvParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *dotenvParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *dotenvParser) next() byte {
+	c := p.s[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *dotenvParser) skipSpaces() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.next()
+	}
+}
+
+func (p *dotenvParser) skipLine() {
+	for !p.eof() && p.next() != '\n' {
+	}
+}
+
+// skipBlank skips blank lines and comments
+func (p *dotenvParser) skipBlank() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\n':
+			p.next()
+		case '#':
+			p.skipLine()
+		default:
+			return
+		}
+	}
+}
+
+func isDotEnvNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+// expand reads a reference after a $, i.e. ${VAR}, ${VAR:-default} or $VAR.
+// A variable which is not set is empty.
+func (p *dotenvParser) expand() (string, error) {
+	if p.peek() == '{' {
+		p.next()
+		end := strings.IndexByte(p.s[p.pos:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${")
+		}
+		ref := p.s[p.pos : p.pos+end]
+		for p.peek() != '}' {
+			p.next()
+		}
+		p.next()
+		name, dflt, hasDefault := strings.Cut(ref, ":-")
+		if val, ok := p.lookup(name); ok && (len(val) > 0 || !hasDefault) {
+			return val, nil
+		}
+		return dflt, nil
+	}
+	if !isDotEnvNameChar(p.peek(), true) {
+		return "$", nil
+	}
+	start := p.pos
+	for !p.eof() && isDotEnvNameChar(p.peek(), false) {
+		p.next()
+	}
+	val, _ := p.lookup(p.s[start:p.pos])
+	return val, nil
+}
+
+// value reads the value of an entry, after the =
+func (p *dotenvParser) value() (string, error) {
+	var b strings.Builder
+	switch p.peek() {
+	case '\'':
+		// taken as is, and can span lines
+		p.next()
+		end := strings.IndexByte(p.s[p.pos:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value")
+		}
+		for n := 0; n < end; n++ {
+			b.WriteByte(p.next())
+		}
+		p.next()
+	case '"':
+		// escapes and ${VAR} are replaced, and can span lines
+		p.next()
+		for {
+			if p.eof() {
+				return "", fmt.Errorf("unterminated quoted value")
+			}
+			c := p.next()
+			if c == '"' {
+				break
+			}
+			switch c {
+			case '\\':
+				if p.eof() {
+					return "", fmt.Errorf("unterminated quoted value")
+				}
+				switch e := p.next(); e {
+				case 'n':
+					b.WriteByte('\n')
+				case 'r':
+					b.WriteByte('\r')
+				case 't':
+					b.WriteByte('\t')
+				case '"', '\\', '$', '\'':
+					b.WriteByte(e)
+				default:
+					b.WriteByte('\\')
+					b.WriteByte(e)
+				}
+			case '$':
+				val, err := p.expand()
+				if err != nil {
+					return "", err
+				}
+				b.WriteString(val)
+			default:
+				b.WriteByte(c)
+			}
+		}
+	default:
+		// the rest of the line, up to a comment
+		prev := byte(' ')
+		for !p.eof() && p.peek() != '\n' {
+			if p.peek() == '#' && (prev == ' ' || prev == '\t') {
+				break
+			}
+			c := p.next()
+			prev = c
+			if c != '$' {
+				b.WriteByte(c)
+				continue
+			}
+			val, err := p.expand()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(val)
+		}
+		p.skipLine()
+		return strings.TrimSpace(b.String()), nil
+	}
+	// only a comment can follow a quoted value
+	p.skipSpaces()
+	if !p.eof() && p.peek() != '\n' && p.peek() != '#' {
+		return "", fmt.Errorf("unexpected %q after the quoted value", p.peek())
+	}
+	p.skipLine()
+	return b.String(), nil
+}
+
+// entry reads one KEY=VALUE line, with an optional export in front
+func (p *dotenvParser) entry() (key string, val string, err error) {
+	if strings.HasPrefix(p.s[p.pos:], "export ") || strings.HasPrefix(p.s[p.pos:], "export\t") {
+		p.pos += len("export")
+		p.skipSpaces()
+	}
+	start := p.pos
+	for !p.eof() && (isDotEnvNameChar(p.peek(), p.pos == start) || (p.pos > start && (p.peek() == '.' || p.peek() == '-'))) {
+		p.next()
+	}
+	key = p.s[start:p.pos]
+	if len(key) < 1 {
+		return "", "", fmt.Errorf("expected KEY=VALUE")
+	}
+	p.skipSpaces()
+	if p.peek() != '=' {
+		return "", "", fmt.Errorf("expected = after %s", key)
+	}
+	p.next()
+	p.skipSpaces()
+	val, err = p.value()
+	return
+}
+
+// parseDotEnv adds the entries of a .env file to vars. ${VAR} is looked up in
+// env, then vars, as a variable which is really set wins over the .env files.
+func parseDotEnv(data string, vars map[string]string, env map[string]string) error {
+	p := &dotenvParser{s: strings.ReplaceAll(data, "\r\n", "\n"), line: 1}
+	p.lookup = func(name string) (string, bool) {
+		if val, ok := env[name]; ok {
+			return val, true
+		}
+		val, ok := vars[name]
+		return val, ok
+	}
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return nil
+		}
+		line := p.line
+		key, val, err := p.entry()
+		if err != nil {
+			return fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		vars[key] = val
+	}
+}
+
+// ParseDotEnv parses the contents of a .env file into a map of its entries. It supports
+// comments, an export in front of an entry, single quoted values which are taken as is,
+// double quoted values with escapes like \n, values spanning several lines in quotes,
+// and ${VAR}, ${VAR:-default} and $VAR, which are looked up in env and then in the
+// earlier entries.
+func ParseDotEnv(data string, env map[string]string) (map[string]string, error) {
+	vars := make(map[string]string)
+	err := parseDotEnv(data, vars, env)
+	if err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// DotEnvToMap reads several .env files, in order, into one map. Entries in later files
+// replace those in earlier ones, and can refer to them with ${VAR}. Files which do not
+// exist are skipped. The process environment is not changed.
+func DotEnvToMap(paths []string, env map[string]string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			debugf("dotenv: %s does not exist\n", path)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err = parseDotEnv(string(data), vars, env); err != nil {
+			return nil, fmt.Errorf("%s %s", path, err.Error())
+		}
+	}
+	return vars, nil
+}
@@ -0,0 +1,93 @@
+package conftagz
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDotEnv(t *testing.T) {
+	data := `# a comment
+APP_HOST=example.com
+export APP_PORT = 8080   # the port
+APP_URL=http://${APP_HOST}:$APP_PORT/
+APP_SINGLE='no ${APP_HOST} \n here'
+APP_DOUBLE="tab\there \"quoted\" \${APP_HOST}" # comment
+APP_CERT="-----BEGIN-----
+abc
+-----END-----"
+APP_RAW='line1
+line2'
+APP_HASH=a#b
+APP_EMPTY=
+APP_DEFAULT=${APP_UNSET:-fallback}
+APP_FROMENV=${HOME_DIR}
+`
+	m, err := ParseDotEnv(data, map[string]string{"HOME_DIR": "/home/x"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"APP_HOST":    "example.com",
+		"APP_PORT":    "8080",
+		"APP_URL":     "http://example.com:8080/",
+		"APP_SINGLE":  `no ${APP_HOST} \n here`,
+		"APP_DOUBLE":  "tab\there \"quoted\" ${APP_HOST}",
+		"APP_CERT":    "-----BEGIN-----\nabc\n-----END-----",
+		"APP_RAW":     "line1\nline2",
+		"APP_HASH":    "a#b",
+		"APP_EMPTY":   "",
+		"APP_DEFAULT": "fallback",
+		"APP_FROMENV": "/home/x",
+	}, m)
+}
+
+func TestParseDotEnvErrors(t *testing.T) {
+	_, err := ParseDotEnv("A=1\nnot an entry\n", nil)
+	assert.EqualError(t, err, "line 2: expected = after not")
+	_, err = ParseDotEnv("A=1\nB=\"open\n\nC=2\n", nil)
+	assert.EqualError(t, err, "line 2: unterminated quoted value")
+	_, err = ParseDotEnv("A='x' y\n", nil)
+	assert.EqualError(t, err, "line 1: unexpected 'y' after the quoted value")
+	_, err = ParseDotEnv("=x\n", nil)
+	assert.EqualError(t, err, "line 1: expected KEY=VALUE")
+}
+
+type DotEnvStruct struct {
+	Host string `env:"APP_HOST"`
+	Port int    `env:"APP_PORT"`
+	URL  string `env:"APP_URL"`
+}
+
+func TestDotEnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, ".env")
+	local := filepath.Join(dir, ".env.local")
+	assert.Nil(t, os.WriteFile(base, []byte("APP_HOST=base\nAPP_PORT=80\nAPP_URL=http://${APP_HOST}\n"), 0o600))
+	assert.Nil(t, os.WriteFile(local, []byte("APP_HOST=local\nAPP_URL=$APP_URL:$APP_PORT\n"), 0o600))
+
+	m, err := DotEnvToMap([]string{base, local, filepath.Join(dir, "missing")}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"APP_HOST": "local", "APP_PORT": "80", "APP_URL": "http://base:80"}, m)
+
+	t.Setenv("APP_PORT", "8080")
+	var s DotEnvStruct
+	_, err = EnvFieldSubstitution(&s, &EnvFieldSubstOpts{DotEnvFiles: []string{base, local}})
+	assert.Nil(t, err)
+	assert.Equal(t, "local", s.Host)
+	assert.Equal(t, 8080, s.Port)
+	_, set := os.LookupEnv("APP_HOST")
+	assert.False(t, set)
+
+	// a variable which is really set is used in ${VAR} too
+	t.Setenv("APP_HOST", "prod")
+	s = DotEnvStruct{}
+	_, err = EnvFieldSubstitution(&s, &EnvFieldSubstOpts{DotEnvFiles: []string{base}})
+	assert.Nil(t, err)
+	assert.Equal(t, "prod", s.Host)
+	assert.Equal(t, "http://prod", s.URL)
+
+	assert.Nil(t, os.WriteFile(local, []byte("APP_HOST\n"), 0o600))
+	_, err = DotEnvToMap([]string{base, local}, nil)
+	assert.EqualError(t, err, local+" line 1: expected = after APP_HOST")
+}
@@ -2,6 +2,7 @@ package conftagz
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"reflect"
 	"strconv"
@@ -11,10 +12,85 @@ import (
 type EnvFieldSubstOpts struct {
 	// throws an error if the environment variable is not found
 	ThrowErrorIfEnvMissing bool
+	// keep going if a field fails, and return a *MultiError with all the failures
+	CollectErrors bool
+	// if true, every field without an env: tag uses the env var named after its path,
+	// as if it was tagged env:"auto". Fields tagged env:"-" are left out.
+	AutoEnv bool
+	// put in front of automatic env var names, i.e. "APP" makes SSL.Cert APP_SSL_CERT
+	Prefix string
+	// automatic env var names are made from the yaml (or json) keys rather than
+	// the field names, i.e. sslstuff.cert rather than SSL.Cert
+	AutoEnvUseKeys bool
+	// put between the parts of an automatic env var name. "_" if empty
+	AutoEnvSeparator string
+	// AUTOENVUPPER (the default), AUTOENVLOWER or AUTOENVASIS
+	AutoEnvCase int
+	// prints a warning when a deprecated env var name is used. log.Printf if not set.
+	WarnFunc func(format string, args ...interface{})
+	// if not nil, filled in with the env var used for each field that had one, by
+	// the path of the field. Useful when an env: tag has more than one name.
+	UsedNames map[string]string
+	// if an env var is not set, but the same name with FILEENVSUFFIX is, i.e. SSL_KEY_FILE,
+	// the value is read from the file it names. This is the docker secrets convention.
+	FileEnv bool
+	// the largest file read for FileEnv or a file: tag. DEFAULTMAXFILESIZE if not set.
+	MaxFileSize int64
+	// read secret files even if everyone can read them
+	AllowWorldReadable bool
+	// .env files read by EnvFieldSubstitution, in order, for env vars which are not
+	// set. Files which do not exist are skipped. See DotEnvToMap
+	DotEnvFiles []string
+	// only set the fields tagged conf:"preferenv". Used by Process to put them
+	// back after a later stage has changed them.
+	preferOnly bool
+	// the map is the files of a directory, rather than the env. See DirFieldSubstitution
+	fromDir bool
+	// the files of the directory which could not be read, by name
+	dirFailed map[string]error
+	// the converters of the Processor running the stage, or the default Processor's if nil
+	converters converterMap
 }
 
 const ENVFIELD = "env"
 
+// DEPRECATEDENV marks a name in an env: tag as deprecated, i.e.
+// env:"APP_WEBHOOK_URL,APP_HOOK_URL:deprecated". It is still used, with a warning.
+const DEPRECATEDENV = ":deprecated"
+
+// envAlias is one of the names in an env: tag
+type envAlias struct {
+	name       string
+	deprecated bool
+}
+
+// parseEnvAliases splits an env: tag into its names, which are tried in order
+func parseEnvAliases(tag string) (aliases []envAlias) {
+	if len(tag) < 1 {
+		return nil
+	}
+	for _, name := range strings.Split(tag, ",") {
+		name = strings.TrimSpace(name)
+		deprecated := strings.HasSuffix(name, DEPRECATEDENV)
+		aliases = append(aliases, envAlias{name: strings.TrimSuffix(name, DEPRECATEDENV), deprecated: deprecated})
+	}
+	return
+}
+
+// findEnvAlias returns the first of the names which is in m. If none are, the first
+// name is returned with found false.
+func findEnvAlias(aliases []envAlias, m map[string]string) (alias envAlias, found bool) {
+	for _, a := range aliases {
+		if _, ok := m[a.name]; ok {
+			return a, true
+		}
+	}
+	if len(aliases) > 0 {
+		alias = aliases[0]
+	}
+	return
+}
+
 func EnvToMap() map[string]string {
 	envMap := make(map[string]string)
 	for _, env := range os.Environ() {
@@ -27,12 +103,25 @@ func EnvToMap() map[string]string {
 // EnvFieldSubstitution is a function that takes a pointer to a struct
 // and looks at each field. If the field has a ENVFIELD tag ("env" by default)
 // then it will look up the value of the field in the environment variables
-// and replace the field with the value.
+// (or the opts.DotEnvFiles) and replace the field with the value.
 // It returns a list of the names of the fields that were substituted - as
 // a list of string
 // If there is an error, it returns an error
 func EnvFieldSubstitution(somestruct interface{}, opts *EnvFieldSubstOpts) (ret []string, err error) {
 	m := EnvToMap()
+	if opts != nil && len(opts.DotEnvFiles) > 0 {
+		var dotenv map[string]string
+		dotenv, err = DotEnvToMap(opts.DotEnvFiles, m)
+		if err != nil {
+			return
+		}
+		// the real env wins
+		for k, v := range dotenv {
+			if _, ok := m[k]; !ok {
+				m[k] = v
+			}
+		}
+	}
 	return EnvFieldSubstitutionFromMap(somestruct, opts, m)
 }
 func StringToInt64(s string) (int64, error) {
@@ -60,6 +149,17 @@ func StringToFloat64(s string) (float64, error) {
 	return i, nil
 }
 
+// isZeroOrNil is true if the field is nil, or is a pointer to a zero value, or is zero
+func isZeroOrNil(v reflect.Value) bool {
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		return v.Elem().IsZero()
+	}
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Map {
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
 func addParentPath(parentpath string, fieldname string) string {
 	if len(parentpath) > 0 {
 		return parentpath + "." + fieldname
@@ -69,13 +169,54 @@ func addParentPath(parentpath string, fieldname string) string {
 
 // EnvFieldSubstitutionFromMap is a function that takes a pointer to a struct
 func EnvFieldSubstitutionFromMap(somestruct interface{}, opts *EnvFieldSubstOpts, m map[string]string) (ret []string, err error) {
-	var throwErrorIfEnvMissing bool
-	if opts != nil {
-		throwErrorIfEnvMissing = opts.ThrowErrorIfEnvMissing
+	if opts == nil {
+		opts = &EnvFieldSubstOpts{}
+	}
+	conv := opts.converters.orDefault()
+	throwErrorIfEnvMissing := opts.ThrowErrorIfEnvMissing
+	collect := opts.CollectErrors
+	preferOnly := opts.preferOnly
+	stage := STAGEENV
+	if opts.fromDir {
+		stage = STAGEDIR
+	}
+	errs := newErrorCollector(stage, collect, somestruct)
+	names := newEnvNameTracker()
+	warnf := log.Printf
+	if opts.WarnFunc != nil {
+		warnf = opts.WarnFunc
+	}
+	// setLocalEnv adds the value read from a secret file to m, as if it was an env
+	// var. m is copied first so the caller's map is not changed.
+	var copied bool
+	setLocalEnv := func(name string, val string) {
+		if !copied {
+			local := make(map[string]string, len(m)+1)
+			for k, v := range m {
+				local[k] = v
+			}
+			m = local
+			copied = true
+		}
+		m[name] = val
 	}
 
-	setEnvVal := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string) error {
+	// setEnvConverted sets a field of a type parsed by convertString, i.e. time.Duration
+	setEnvConverted := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, val string, layout string) error {
+		cval, err := conv.convertString(fieldValue.Type(), val, layout)
+		if err != nil {
+			return fmt.Errorf("map (env) %s value %s %s", tag, val, err.Error())
+		}
+		fieldValue.Set(cval)
+		ret = append(ret, addParentPath(parentpath, fieldName))
+		return nil
+	}
+
+	setEnvVal := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, layout string) error {
 		if val, ok := m[tag]; ok {
+			if conv.isConvertedType(fieldValue.Type()) {
+				return setEnvConverted(parentpath, fieldName, fieldValue, tag, val, layout)
+			}
 			k := fieldValue.Kind()
 			switch k {
 			// TODO - add support for Ptr to String and Ints
@@ -120,8 +261,11 @@ func EnvFieldSubstitutionFromMap(somestruct interface{}, opts *EnvFieldSubstOpts
 		return nil
 	}
 
-	setEnvValPtr := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string) error {
+	setEnvValPtr := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, layout string) error {
 		if val, ok := m[tag]; ok {
+			if conv.isConvertedType(fieldValue.Elem().Type()) {
+				return setEnvConverted(parentpath, fieldName, fieldValue.Elem(), tag, val, layout)
+			}
 			k := fieldValue.Elem().Kind()
 			switch k {
 			// TODO - add support for Ptr to String and Ints
@@ -167,6 +311,55 @@ func EnvFieldSubstitutionFromMap(somestruct interface{}, opts *EnvFieldSubstOpts
 		return nil
 	}
 
+	// setEnvMap sets a map from the key=value pairs in the env var, i.e. LABELS="a=1,b=2"
+	setEnvMap := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, layout string, confops map[string]string) error {
+		if val, ok := m[tag]; ok {
+			if conv.isMapOfStructs(fieldValue.Type()) {
+				return fmt.Errorf("map (env) for %s underlying type unsupported (setEnvMap)", fieldValue.Type().String())
+			}
+			newmap, err := conv.parseMap(fieldValue.Type(), val, listSep(confops), layout)
+			if err != nil {
+				return fmt.Errorf("map (env) %s value %s %s", tag, val, err.Error())
+			}
+			if appendList(confops) {
+				mergeMap(fieldValue, newmap)
+			} else {
+				fieldValue.Set(newmap)
+			}
+			ret = append(ret, addParentPath(parentpath, fieldName))
+		} else {
+			if throwErrorIfEnvMissing {
+				return fmt.Errorf("env %s not found", tag)
+			}
+		}
+		return nil
+	}
+
+	// setEnvSlice sets a slice from the list in the env var, i.e. PEERS="a,b"
+	setEnvSlice := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, layout string, confops map[string]string) error {
+		if val, ok := m[tag]; ok {
+			if !conv.isScalarSliceType(fieldValue.Type()) {
+				return fmt.Errorf("map (env) for %s underlying type unsupported (setEnvSlice)", fieldValue.Type().String())
+			}
+			newslice := reflect.New(fieldValue.Type()).Elem()
+			err := conv.appendSliceElems(newslice, splitList(val, listSep(confops)), layout)
+			if err != nil {
+				return fmt.Errorf("map (env) %s value %s %s", tag, val, err.Error())
+			}
+			if appendList(confops) {
+				fieldValue.Set(reflect.AppendSlice(fieldValue, newslice))
+			} else {
+				fieldValue.Set(newslice)
+			}
+			ret = append(ret, addParentPath(parentpath, fieldName))
+		} else {
+			if throwErrorIfEnvMissing {
+				return fmt.Errorf("env %s not found", tag)
+			}
+		}
+		return nil
+	}
+
 	var innerSubst func(parentpath string, somestruct interface{}) (err error)
 
 	innerSubst = func(parentpath string, somestruct interface{}) (err error) {
@@ -190,7 +383,28 @@ func EnvFieldSubstitutionFromMap(somestruct interface{}, opts *EnvFieldSubstOpts
 			field := inputType.Field(i)
 
 			// Get the field tag value
-			tag := field.Tag.Get("env")
+			// the first name can be automatic, the others are old names for the env var
+			aliases := parseEnvAliases(field.Tag.Get(ENVFIELD))
+			var rawtag string
+			if len(aliases) > 0 {
+				rawtag = aliases[0].name
+			}
+			primary := envTagName(opts, errs.root, addParentPath(parentpath, field.Name), field, rawtag)
+			switch {
+			case len(primary) < 1:
+				aliases = nil
+			case len(aliases) > 0:
+				aliases[0].name = primary
+			default:
+				aliases = []envAlias{{name: primary}}
+			}
+			if opts.fromDir {
+				// the conf: options about env vars do not apply to the files
+				aliases = conv.dirKeys(errs.root, addParentPath(parentpath, field.Name), field, aliases)
+				primary, rawtag = "", ""
+			}
+			used, found := findEnvAlias(aliases, m)
+			tag := used.name
 			//			defaultval := field.Tag.Get("default")
 			conftags := field.Tag.Get("conf")
 			confops := processConfTagOptsValues(conftags)
@@ -198,7 +412,7 @@ func EnvFieldSubstitutionFromMap(somestruct interface{}, opts *EnvFieldSubstOpts
 			if skipField(confops) {
 				continue
 			}
-			if envSkip(confops) {
+			if envSkip(confops) && !opts.fromDir {
 				continue
 			}
 			debugf("env: Field Name: %s, Env val: %s\n", field.Name, tag)
@@ -210,6 +424,67 @@ func EnvFieldSubstitutionFromMap(somestruct interface{}, opts *EnvFieldSubstOpts
 				debugf("env: Field %s is not exported\n", field.Name)
 				continue
 			}
+			if opts.fromDir {
+				if name, readErr := dirReadError(aliases, m, opts.dirFailed); readErr != nil {
+					err = errs.add(addParentPath(parentpath, field.Name), name, nil, readErr)
+					if err != nil {
+						return
+					}
+					continue
+				}
+			}
+			var filetag string
+			if !opts.fromDir {
+				filetag = field.Tag.Get(FILEFIELD)
+			}
+			if len(tag) < 1 {
+				tag = filetag
+			}
+			if len(tag) > 0 && conv.isLeafType(field.Type) {
+				if preferOnly && !preferEnv(confops) {
+					continue
+				}
+				if nameErr := names.add(primary, addParentPath(parentpath, field.Name), primary != rawtag); len(primary) > 0 && nameErr != nil {
+					err = errs.add(addParentPath(parentpath, field.Name), tag, nil, nameErr)
+					if err != nil {
+						return
+					}
+					continue
+				}
+				if !found {
+					name, val, ok, fileErr := lookupSecretFile(opts, aliases, filetag, m)
+					if fileErr != nil {
+						err = errs.add(addParentPath(parentpath, field.Name), name, nil, fileErr)
+						if err != nil {
+							return
+						}
+						continue
+					}
+					if ok {
+						debugf("env: Field %s read from file for %s\n", field.Name, name)
+						setLocalEnv(name, val)
+						tag = name
+						found = true
+					}
+				}
+				if !found && mustEnv(confops) && !preferOnly && !opts.fromDir {
+					err = errs.add(addParentPath(parentpath, field.Name), tag, nil, fmt.Errorf("env %s not found", tag))
+					if err != nil {
+						return
+					}
+					continue
+				}
+				if backupEnv(confops) && !isZeroOrNil(fieldValue) && !opts.fromDir {
+					debugf("env: Field %s has a value and is backupenv\n", field.Name)
+					continue
+				}
+				if found && opts.UsedNames != nil {
+					opts.UsedNames[addParentPath(parentpath, field.Name)] = tag
+				}
+				if found && used.deprecated && !preferOnly {
+					warnf("warning: env %s (field %s) is deprecated, use %s\n", tag, addParentPath(parentpath, field.Name), primary)
+				}
+			}
 			if field.Type.Kind() == reflect.Ptr {
 				// recurse
 				fieldValue := inputValue.FieldByName(field.Name)
@@ -233,7 +508,12 @@ func EnvFieldSubstitutionFromMap(somestruct interface{}, opts *EnvFieldSubstOpts
 						}
 					default:
 						debugf("env: Got a NON-fundamental type: %s %s which is a %s\n", t.Kind().String(), t.Elem().String(), t.Elem().Kind().String())
-						if fieldValue.CanSet() {
+						if conv.isConvertedType(t.Elem()) {
+							// i.e. a *time.Time, which is set like a fundamental type
+							if _, ok := m[tag]; ok {
+								fieldValue.Set(reflect.New(t.Elem()))
+							}
+						} else if fieldValue.CanSet() {
 							if t.Elem().Kind() == reflect.Struct {
 								fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
 							} else {
@@ -248,7 +528,7 @@ func EnvFieldSubstitutionFromMap(somestruct interface{}, opts *EnvFieldSubstOpts
 
 				if !fieldValue.IsNil() {
 					// is this a Ptr to a struct?
-					if t.Elem().Kind() == reflect.Struct {
+					if conv.isStructType(t.Elem()) {
 						err := innerSubst(addParentPath(parentpath, field.Name), fieldValue.Elem().Addr().Interface())
 						if err != nil {
 							return err
@@ -256,7 +536,7 @@ func EnvFieldSubstitutionFromMap(somestruct interface{}, opts *EnvFieldSubstOpts
 					} else {
 						// nope then its just a fundamental type
 						if len(tag) > 0 {
-							err = setEnvValPtr(parentpath, field.Name, fieldValue, tag)
+							err = errs.add(addParentPath(parentpath, field.Name), tag, m[tag], setEnvValPtr(parentpath, field.Name, fieldValue, tag, field.Tag.Get(LAYOUTFIELD)))
 							if err != nil {
 								return
 							}
@@ -264,7 +544,27 @@ func EnvFieldSubstitutionFromMap(somestruct interface{}, opts *EnvFieldSubstOpts
 
 					}
 				}
-			} else if field.Type.Kind() == reflect.Struct {
+			} else if conv.isMapType(field.Type) {
+				if len(tag) > 0 {
+					err = errs.add(addParentPath(parentpath, field.Name), tag, m[tag], setEnvMap(parentpath, field.Name, fieldValue, tag, field.Tag.Get(LAYOUTFIELD), confops))
+					if err != nil {
+						return
+					}
+				}
+				if conv.isMapOfStructs(field.Type) && !fieldValue.IsNil() {
+					err := walkMapStructs(addParentPath(parentpath, field.Name), fieldValue, innerSubst)
+					if err != nil {
+						return err
+					}
+				}
+			} else if field.Type.Kind() == reflect.Slice && !conv.isConvertedType(field.Type) {
+				if len(tag) > 0 {
+					err = errs.add(addParentPath(parentpath, field.Name), tag, m[tag], setEnvSlice(parentpath, field.Name, fieldValue, tag, field.Tag.Get(LAYOUTFIELD), confops))
+					if err != nil {
+						return
+					}
+				}
+			} else if conv.isStructType(field.Type) {
 				// recurse
 				fieldValue := inputValue.FieldByName(field.Name)
 				// is this a Ptr to a struct?
@@ -274,7 +574,7 @@ func EnvFieldSubstitutionFromMap(somestruct interface{}, opts *EnvFieldSubstOpts
 				}
 			} else if fieldValue.CanSet() {
 				if len(tag) > 0 {
-					err = setEnvVal(parentpath, field.Name, fieldValue, tag)
+					err = errs.add(addParentPath(parentpath, field.Name), tag, m[tag], setEnvVal(parentpath, field.Name, fieldValue, tag, field.Tag.Get(LAYOUTFIELD)))
 					if err != nil {
 						return
 					}
@@ -291,6 +591,6 @@ func EnvFieldSubstitutionFromMap(somestruct interface{}, opts *EnvFieldSubstOpts
 	}
 
 	err = innerSubst("", somestruct)
-	return ret, err
+	return ret, errs.result(err)
 
 }
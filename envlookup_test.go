@@ -1,6 +1,8 @@
 package conftagz
 
 import (
+	"flag"
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -200,3 +202,99 @@ func TestEnvFieldsWithSlice(t *testing.T) {
 	assert.Equal(t, mystruct.Field1, "NewValue1")
 
 }
+
+type EnvConfOpsStruct struct {
+	Token   string `env:"CONFOPS_TOKEN" conf:"mustenv"`
+	Region  string `env:"CONFOPS_REGION" conf:"backupenv"`
+	Zone    *int   `env:"CONFOPS_ZONE" conf:"backupenv"`
+	Level   string `env:"CONFOPS_LEVEL" flag:"level" default:"info" conf:"preferenv"`
+	Verbose string `env:"CONFOPS_VERBOSE" flag:"verbose"`
+}
+
+func TestEnvMustEnv(t *testing.T) {
+	var s EnvConfOpsStruct
+	_, err := EnvFieldSubstitutionFromMap(&s, nil, map[string]string{"CONFOPS_REGION": "eu"})
+	assert.EqualError(t, err, "field Token: env CONFOPS_TOKEN not found")
+	var fe *FieldError
+	assert.ErrorAs(t, err, &fe)
+	assert.Equal(t, "CONFOPS_TOKEN", fe.Tag)
+
+	touched, err := EnvFieldSubstitutionFromMap(&s, nil, map[string]string{"CONFOPS_TOKEN": "secret"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Token"}, touched)
+}
+
+func TestEnvBackupEnv(t *testing.T) {
+	one := 1
+	s := EnvConfOpsStruct{Region: "us"}
+	m := map[string]string{"CONFOPS_TOKEN": "x", "CONFOPS_REGION": "eu", "CONFOPS_ZONE": "3"}
+	_, err := EnvFieldSubstitutionFromMap(&s, nil, m)
+	assert.Nil(t, err)
+	assert.Equal(t, "us", s.Region)
+	assert.Equal(t, 3, *s.Zone)
+
+	s = EnvConfOpsStruct{Zone: &one}
+	_, err = EnvFieldSubstitutionFromMap(&s, nil, m)
+	assert.Nil(t, err)
+	assert.Equal(t, "eu", s.Region)
+	assert.Equal(t, 1, *s.Zone)
+}
+
+func TestProcessPreferEnv(t *testing.T) {
+	t.Setenv("CONFOPS_TOKEN", "x")
+	t.Setenv("CONFOPS_LEVEL", "debug")
+	t.Setenv("CONFOPS_VERBOSE", "env")
+	var s EnvConfOpsStruct
+	prov := Provenance{}
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := NewProcessor(nil).Process(&ConfTagOpts{
+		OrderOfOps:  []int{ENVTAGS, FLAGTAGS, DEFAULTTAGS},
+		FlagTagOpts: &FlagFieldSubstOpts{UseFlags: flagset, Args: []string{"-level", "warn", "-verbose", "flag"}},
+		Provenance:  prov,
+	}, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, "debug", s.Level)
+	assert.Equal(t, "flag", s.Verbose)
+	assert.Equal(t, STAGEENV, prov["Level"].Stage)
+	assert.Equal(t, STAGEFLAG, prov["Level"].Overrode[len(prov["Level"].Overrode)-1].Stage)
+}
+
+type EnvAliasStruct struct {
+	WebhookURL string `env:"APP_WEBHOOK_URL,APP_HOOK_URL:deprecated,HOOK_URL:deprecated"`
+	Region     string `env:"APP_REGION,AWS_REGION"`
+}
+
+func TestEnvAliases(t *testing.T) {
+	var warnings []string
+	warnf := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	var s EnvAliasStruct
+	used := map[string]string{}
+	m := map[string]string{"HOOK_URL": "old", "APP_HOOK_URL": "http://hook", "AWS_REGION": "eu"}
+	_, err := EnvFieldSubstitutionFromMap(&s, &EnvFieldSubstOpts{WarnFunc: warnf, UsedNames: used}, m)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://hook", s.WebhookURL)
+	assert.Equal(t, "eu", s.Region)
+	assert.Equal(t, map[string]string{"WebhookURL": "APP_HOOK_URL", "Region": "AWS_REGION"}, used)
+	assert.Equal(t, []string{"warning: env APP_HOOK_URL (field WebhookURL) is deprecated, use APP_WEBHOOK_URL\n"}, warnings)
+
+	warnings = nil
+	m["APP_WEBHOOK_URL"] = "http://webhook"
+	_, err = EnvFieldSubstitutionFromMap(&s, &EnvFieldSubstOpts{WarnFunc: warnf}, m)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://webhook", s.WebhookURL)
+	assert.Empty(t, warnings)
+
+	_, err = EnvFieldSubstitutionFromMap(&s, &EnvFieldSubstOpts{ThrowErrorIfEnvMissing: true}, nil)
+	assert.EqualError(t, err, "field WebhookURL: env APP_WEBHOOK_URL not found")
+}
+
+func TestEnvAliasProvenance(t *testing.T) {
+	t.Setenv("AWS_REGION", "eu")
+	var s EnvAliasStruct
+	prov := Provenance{}
+	err := NewProcessor(nil).Process(&ConfTagOpts{OrderOfOps: []int{ENVTAGS}, Provenance: prov}, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, "AWS_REGION", prov["Region"].Key)
+}
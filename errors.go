@@ -0,0 +1,208 @@
+package conftagz
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Names of the stages which can report errors
+const (
+	STAGEENV     = "env"
+	STAGEDEFAULT = "default"
+	STAGEFLAG    = "flag"
+	STAGETEST    = "test"
+	STAGEFILE    = "file"
+	STAGEDIR     = "dir"
+	STAGEINTERP  = "interpolate"
+)
+
+// FieldError is the error returned when a single field fails in one of the
+// stages, i.e. an env var could not be converted, or a test: failed. Use
+// errors.As to retrieve it:
+//
+//	var fe *conftagz.FieldError
+//	if errors.As(err, &fe) {
+//		fmt.Printf("%s (%s) failed %s%s\n", fe.Path, fe.Key, fe.Op, fe.Tag)
+//	}
+type FieldError struct {
+	// Path is the dotted path of the field in the struct, i.e. "SSL.Cert" or "Servers[1].IP"
+	Path string
+	// Key is the same path, but using the yaml (or json) key names, i.e. "sslstuff.cert"
+	Key string
+	// Stage is the stage which failed: STAGEENV, STAGEDEFAULT, STAGEFLAG or STAGETEST
+	Stage string
+	// Tag is the text of the tag being processed, i.e. ">=1024,<65537" or "APP_PORT"
+	Tag string
+	// Op is the test operator which failed, i.e. ">=". Only set for the test stage.
+	Op string
+	// Value is the offending value. For the test stage this is the value of the field,
+	// for the env stage the env var value and for the default stage the default value.
+	Value interface{}
+	// Err is the underlying error
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field %s: %s", e.Path, e.Err.Error())
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// keyPath converts a dotted field path like "SSL.Cert" or "Servers[1].IP" to
+// the path of yaml (or json) keys, i.e. "sslstuff.cert" or "servers[1].ip"
+func keyPath(t reflect.Type, path string) string {
+	var keys []string
+	for _, part := range strings.Split(path, ".") {
+		name := part
+		var index string
+		if n := strings.Index(part, "["); n > 0 {
+			name = part[:n]
+			index = part[n:]
+		}
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			keys = append(keys, part)
+			continue
+		}
+		field, ok := t.FieldByName(name)
+		if !ok {
+			keys = append(keys, part)
+			continue
+		}
+		keys = append(keys, fieldKeyName(field)+index)
+		t = field.Type
+		if len(index) > 0 {
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+				t = t.Elem()
+			}
+		}
+	}
+	return strings.Join(keys, ".")
+}
+
+// fieldKeyName returns the name of the field as it would appear in a
+// yaml or json file
+func fieldKeyName(field reflect.StructField) string {
+	for _, tagname := range []string{"yaml", "json"} {
+		name := strings.Split(field.Tag.Get(tagname), ",")[0]
+		if len(name) > 0 && name != "-" {
+			return name
+		}
+	}
+	// the default used by yaml.v2
+	return strings.ToLower(field.Name)
+}
+
+// MultiError is returned by Process and the individual stages when
+// CollectErrors is set and one or more fields failed. It holds one error
+// per failing field, in the order they were found.
+//
+// MultiError implements Unwrap() []error so errors.Is and errors.As
+// look at every field error it holds.
+type MultiError struct {
+	Errors []error
+}
+
+func (m *MultiError) Error() string {
+	if len(m.Errors) == 1 {
+		return errorLine(m.Errors[0])
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d errors:", len(m.Errors))
+	for _, err := range m.Errors {
+		b.WriteString("\n\t")
+		b.WriteString(errorLine(err))
+	}
+	return b.String()
+}
+
+// errorLine is the text for err in a list of errors. Field errors
+// are prefixed with their stage.
+func errorLine(err error) string {
+	if fe, ok := err.(*FieldError); ok && len(fe.Stage) > 0 {
+		return fe.Stage + ": " + fe.Error()
+	}
+	return err.Error()
+}
+
+func (m *MultiError) Unwrap() []error {
+	return m.Errors
+}
+
+// append adds err to the list. If err is itself a MultiError, or the result
+// of errors.Join, its errors are added individually.
+func (m *MultiError) append(err error) {
+	if err == nil {
+		return
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			m.append(e)
+		}
+		return
+	}
+	m.Errors = append(m.Errors, err)
+}
+
+// errOrNil returns nil if no errors were collected
+func (m *MultiError) errOrNil() error {
+	if len(m.Errors) == 0 {
+		return nil
+	}
+	return m
+}
+
+// errorCollector is used by each stage to either stop on the first failing field,
+// or if collecting, record the failing field and keep going
+type errorCollector struct {
+	stage   string
+	collect bool
+	// the type of the struct being processed, used to find the yaml key names
+	root reflect.Type
+	errs MultiError
+}
+
+func newErrorCollector(stage string, collect bool, somestruct interface{}) *errorCollector {
+	return &errorCollector{stage: stage, collect: collect, root: reflect.TypeOf(somestruct)}
+}
+
+// add records that the field at path failed. tag is the text of the tag being
+// processed and value is the offending value. If not collecting, the *FieldError is
+// returned so the caller can bail out. If collecting, nil is returned.
+func (c *errorCollector) add(path string, tag string, value interface{}, err error) error {
+	return c.addField(&FieldError{Path: path, Tag: tag, Value: value, Err: err})
+}
+
+func (c *errorCollector) addField(fe *FieldError) error {
+	if fe.Err == nil {
+		return nil
+	}
+	fe.Stage = c.stage
+	if c.root != nil {
+		fe.Key = keyPath(c.root, fe.Path)
+	}
+	if !c.collect {
+		return fe
+	}
+	c.errs.append(fe)
+	return nil
+}
+
+// result returns the final error for the stage. err is any error which stopped the stage.
+func (c *errorCollector) result(err error) error {
+	if !c.collect {
+		return err
+	}
+	if err != nil {
+		c.errs.append(fmt.Errorf("%s: %w", c.stage, err))
+	}
+	return c.errs.errOrNil()
+}
@@ -0,0 +1,123 @@
+package conftagz
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type MultiErrStruct struct {
+	Port    int    `env:"MULTI_PORT" test:">=1024"`
+	Retries int    `default:"notanumber"`
+	Name    string `test:"~^[a-z]+$"`
+	Count   uint   `env:"MULTI_COUNT" test:"<10"`
+}
+
+var errSentinel = errors.New("sentinel")
+
+func TestMultiErrorUnwrap(t *testing.T) {
+	var m MultiError
+	m.append(errSentinel)
+	m.append(errors.Join(errors.New("one"), errors.New("two")))
+	assert.Equal(t, 3, len(m.Errors))
+	assert.True(t, errors.Is(m.errOrNil(), errSentinel))
+	assert.Equal(t, "3 errors:\n\tsentinel\n\tone\n\ttwo", m.Error())
+
+	// a single error prints just like the error
+	single := &MultiError{Errors: []error{errSentinel}}
+	assert.Equal(t, "sentinel", single.Error())
+
+	var empty MultiError
+	assert.Nil(t, empty.errOrNil())
+}
+
+func TestEnvCollectErrors(t *testing.T) {
+	mystruct := MultiErrStruct{}
+	envMap := map[string]string{
+		"MULTI_PORT":  "eighty",
+		"MULTI_COUNT": "-1",
+	}
+
+	_, err := EnvFieldSubstitutionFromMap(&mystruct, &EnvFieldSubstOpts{CollectErrors: true}, envMap)
+	var multi *MultiError
+	assert.True(t, errors.As(err, &multi))
+	assert.Equal(t, 2, len(multi.Errors))
+	assert.EqualError(t, err, "2 errors:\n"+
+		"\tenv: field Port: map (env) MULTI_PORT value eighty not a number\n"+
+		"\tenv: field Count: map (env) MULTI_COUNT value -1 not a number")
+
+	// without CollectErrors the first error stops the run
+	_, err = EnvFieldSubstitutionFromMap(&mystruct, nil, envMap)
+	assert.False(t, errors.As(err, &multi))
+	assert.EqualError(t, err, "field Port: map (env) MULTI_PORT value eighty not a number")
+
+	var fe *FieldError
+	assert.True(t, errors.As(err, &fe))
+	assert.Equal(t, STAGEENV, fe.Stage)
+	assert.Equal(t, "MULTI_PORT", fe.Tag)
+	assert.Equal(t, "eighty", fe.Value)
+}
+
+func TestTestCollectErrors(t *testing.T) {
+	mystruct := MultiErrStruct{Port: 80, Name: "UPPER", Count: 20}
+
+	result, err := RunTestFlags(&mystruct, &TestFieldSubstOpts{CollectErrors: true})
+	assert.Equal(t, []string{"Port", "Name", "Count"}, result)
+	assert.EqualError(t, err, "3 errors:\n"+
+		"\ttest: field Port: value 80 ! >= 1024\n"+
+		"\ttest: field Name: value \"UPPER\" !~ regexp ^[a-z]+$\n"+
+		"\ttest: field Count: value 20 ! < 10")
+}
+
+type FieldErrInner struct {
+	IP string `yaml:"ip" test:"~^[0-9.]+$"`
+}
+
+type FieldErrStruct struct {
+	Port    int              `yaml:"port" test:">=1024,<65537"`
+	Servers []*FieldErrInner `yaml:"servers"`
+	SSL     *FieldErrSSL     `json:"ssl"`
+}
+
+type FieldErrSSL struct {
+	Cert string `test:"~.{10,}"`
+}
+
+func TestFieldError(t *testing.T) {
+	mystruct := FieldErrStruct{
+		Port:    99999,
+		Servers: []*FieldErrInner{{"1.2.3.4"}, {"bad"}},
+		SSL:     &FieldErrSSL{"short"},
+	}
+
+	_, err := RunTestFlags(&mystruct, nil)
+	assert.EqualError(t, err, "field Port: value 99999 ! < 65537")
+	var fe *FieldError
+	if !errors.As(err, &fe) {
+		t.Errorf("Expected a *FieldError got %v", err)
+		return
+	}
+	assert.Equal(t, "Port", fe.Path)
+	assert.Equal(t, "port", fe.Key)
+	assert.Equal(t, STAGETEST, fe.Stage)
+	assert.Equal(t, ">=1024,<65537", fe.Tag)
+	assert.Equal(t, "<", fe.Op)
+	assert.Equal(t, 99999, fe.Value)
+
+	mystruct.Port = 8080
+	_, err = RunTestFlags(&mystruct, &TestFieldSubstOpts{CollectErrors: true})
+	var multi *MultiError
+	assert.True(t, errors.As(err, &multi))
+	assert.Equal(t, 2, len(multi.Errors))
+
+	fe = multi.Errors[0].(*FieldError)
+	assert.Equal(t, "Servers[1].IP", fe.Path)
+	assert.Equal(t, "servers[1].ip", fe.Key)
+	assert.Equal(t, "~", fe.Op)
+	assert.Equal(t, "bad", fe.Value)
+
+	fe = multi.Errors[1].(*FieldError)
+	assert.Equal(t, "SSL.Cert", fe.Path)
+	assert.Equal(t, "ssl.cert", fe.Key)
+}
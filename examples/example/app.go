@@ -3,49 +3,34 @@ package main
 import (
 	"fmt"
 	"log"
-	"os"
 	"time"
 
 	"go.izuma.io/conftagz"
-	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	WebhookURL string `yaml:"webhook_url" env:"APP_HOOK_URL" test:"~https://.*"`
-	Port       int    `yaml:"port" env:"APP_PORT" default:"8888" flag:"port" test:">=1024,<65537" usage:"Port to listen on"`
-	Expiration string `yaml:"expiration" default:"1h" test:"$(validtimeduration)"`
-	DebugMode  bool   `yaml:"debug_mode" env:"DEBUG" flag:"debug"`
-}
-
-func ValidTimeDuration(val interface{}, fieldname string) bool {
-	_, err := time.ParseDuration(val.(string))
-	return err == nil
+	WebhookURL string        `yaml:"webhook_url" env:"APP_HOOK_URL" test:"~https://.*"`
+	Port       int           `yaml:"port" env:"APP_PORT" default:"8888" flag:"port" test:">=1024,<65537" usage:"Port to listen on"`
+	Expiration time.Duration `yaml:"expiration" default:"1h" test:">=1m,<=720h"`
+	DebugMode  bool          `yaml:"debug_mode" env:"DEBUG" flag:"debug"`
 }
 
 func RunMain() {
 
 	var config Config
-	var confFileName string = "config.yaml"
-
-	// load config file from yaml using yaml parser
-	// Read the yaml file
-	data, err := os.ReadFile(confFileName)
-	if err != nil {
-		log.Fatalf("error with ReadFile(%s): %v", confFileName, err)
-	}
-
-	// Unmarshal the yaml file into the config struct
-	err = yaml.Unmarshal([]byte(data), &config)
-	if err != nil {
-		log.Fatalf("error with yaml.Unmarshal: %v", err)
-	}
-	// register that custom test
-	conftagz.RegisterTestFunc("validtimeduration", ValidTimeDuration)
 
 	// Run conftagz on the config struct
-	// to validate the config, sub any env vars,
+	// to load config.yaml (or the file given with -config or APP_CONFIG),
+	// validate the config, sub any env vars,
 	// and put in defaults for missing items
-	err = conftagz.Process(nil, &config)
+	err := conftagz.Process(&conftagz.ConfTagOpts{
+		FileOpts: &conftagz.ConfigFileOpts{
+			Paths:     []string{"config.yaml"},
+			PathEnv:   "APP_CONFIG",
+			PathFlag:  "config",
+			MustExist: true,
+		},
+	}, &config)
 	if err != nil {
 		// some test tag failed
 		log.Fatalf("Config is bad: %v\n", err)
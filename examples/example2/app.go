@@ -13,13 +13,13 @@ import (
 // Example with multiple structs
 
 type Server struct {
-	IP   string `yaml:"ip" test:"~[0-9]{1,3}\\.[0-9]{1,3}\\.[0-9]{1,3}\\.[0-9]{1,3}$"`
-	Name string `yaml:"name" test:"~.+"`
+	IP   string `yaml:"ip" test:"$(ipv4)"`
+	Name string `yaml:"name" test:"notblank"`
 }
 type SSLStuff struct {
 	// cert and key should be at least 10 chars long
-	Cert string `yaml:"cert" env:"SSL_CERT" test:"~.{10,}"`
-	Key  string `yaml:"key" env:"SSL_KEY" test:"~.{10,}"`
+	Cert string `yaml:"cert" env:"SSL_CERT" test:"len>=10"`
+	Key  string `yaml:"key" env:"SSL_KEY" test:"len>=10"`
 }
 
 type LogSetup struct {
@@ -0,0 +1,138 @@
+package conftagz
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// fieldRefRE matches a reference to another field in a default: tag. ${.Host} is the
+// Host field next to the one with the tag, ${/SSL.Port} is a path from the top of
+// the struct, and ${.Port+1} adds to a number.
+var fieldRefRE = regexp.MustCompile(`\$\{([./])([A-Za-z_][A-Za-z0-9_.\[\]]*)\s*(?:([+-])\s*([0-9.]+))?\}`)
+
+// hasFieldRefs is true if the default: tag refers to other fields
+func hasFieldRefs(defaultval string) bool {
+	return fieldRefRE.MatchString(defaultval)
+}
+
+// parentPath returns the path of the struct holding the field at path
+func parentPath(path string) string {
+	if n := strings.LastIndex(path, "."); n >= 0 {
+		return path[:n]
+	}
+	return ""
+}
+
+// lookupFieldPath finds the field at path, i.e. "SSL.Cert", "Servers[1].IP" or
+// "Backends[primary].Port". A nil pointer on the way is followed as a zero value,
+// as the default stage may yet create it. A map index is parsed into the type of the keys.
+func (c converterMap) lookupFieldPath(v reflect.Value, path string) (reflect.Value, reflect.StructField, bool) {
+	var field reflect.StructField
+	for _, part := range strings.Split(path, ".") {
+		name, index := part, ""
+		if n := strings.Index(part, "["); n > 0 {
+			name, index = part[:n], strings.Trim(part[n:], "[]")
+		}
+		v = derefOrZero(v)
+		if v.Kind() != reflect.Struct {
+			return v, field, false
+		}
+		f, ok := v.Type().FieldByName(name)
+		if !ok || !f.IsExported() {
+			return v, field, false
+		}
+		field = f
+		v = v.FieldByIndex(f.Index)
+		if len(index) < 1 {
+			continue
+		}
+		v = derefOrZero(v)
+		switch v.Kind() {
+		case reflect.Slice:
+			n, err := strconv.Atoi(index)
+			if err != nil || n < 0 || n >= v.Len() {
+				return v, field, false
+			}
+			v = v.Index(n)
+		case reflect.Map:
+			key, err := c.convertScalar(v.Type().Key(), index, "")
+			if err != nil {
+				return v, field, false
+			}
+			v = v.MapIndex(key)
+			if !v.IsValid() {
+				return v, field, false
+			}
+		default:
+			return v, field, false
+		}
+	}
+	return v, field, true
+}
+
+// derefOrZero follows pointers. A nil pointer is followed to the zero value of its type.
+func derefOrZero(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Zero(v.Type().Elem())
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// formatValue writes a value as a string, the way it would be written in a tag
+func (c converterMap) formatValue(v reflect.Value) string {
+	v = derefOrZero(v)
+	if !v.IsValid() || !v.CanInterface() {
+		return ""
+	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		if text, err := m.MarshalText(); err == nil {
+			return string(text)
+		}
+	}
+	if v.CanAddr() {
+		if m, ok := v.Addr().Interface().(encoding.TextMarshaler); ok {
+			if text, err := m.MarshalText(); err == nil {
+				return string(text)
+			}
+		}
+	}
+	if v.Kind() == reflect.Slice && c.isScalarType(v.Type().Elem()) {
+		var items []string
+		for n := 0; n < v.Len(); n++ {
+			items = append(items, c.formatValue(v.Index(n)))
+		}
+		return strings.Join(items, DEFAULTSEP)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// addToNumber adds (or subtracts) offset to s, which must be a number
+func addToNumber(s string, op string, offset string) (string, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if o, err := strconv.ParseInt(offset, 10, 64); err == nil {
+			if op == "-" {
+				o = -o
+			}
+			return strconv.FormatInt(i+o, 10), nil
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a number", s)
+	}
+	o, err := strconv.ParseFloat(offset, 64)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a number", offset)
+	}
+	if op == "-" {
+		o = -o
+	}
+	return strconv.FormatFloat(f+o, 'f', -1, 64), nil
+}
@@ -0,0 +1,152 @@
+package conftagz
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type RefTLS struct {
+	Port    int    `default:"${/Port+443}"`
+	CertDir string `default:"${/DataDir}/certs"`
+}
+
+type RefBackend struct {
+	Name string
+	URL  string `default:"http://${.Name}.internal:${/Port}"`
+}
+
+type RefStruct struct {
+	// PublicURL comes before the fields it uses
+	PublicURL   string                 `default:"http://${.Host}:${.Port}"`
+	Host        string                 `default:"localhost"`
+	Port        int                    `default:"8080"`
+	MetricsPort int                    `default:"${.Port+1}"`
+	AdminPort   *int                   `default:"${.Port-1}"`
+	DataDir     string                 `default:"/var/lib/app"`
+	Timeout     time.Duration          `default:"30s"`
+	IdleTimeout time.Duration          `default:"${.Timeout}"`
+	TLS         *RefTLS                ``
+	Backends    map[string]*RefBackend ``
+	Ratio       float64                `default:"0.5"`
+	Ratio2      float64                `default:"${.Ratio+0.25}"`
+}
+
+func TestDefaultFieldRefs(t *testing.T) {
+	s := RefStruct{Port: 9000, Backends: map[string]*RefBackend{"db": {Name: "db"}}}
+	_, err := SubsistuteDefaults(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://localhost:9000", s.PublicURL)
+	assert.Equal(t, 9001, s.MetricsPort)
+	assert.Equal(t, 8999, *s.AdminPort)
+	assert.Equal(t, 30*time.Second, s.IdleTimeout)
+	assert.Equal(t, 9443, s.TLS.Port)
+	assert.Equal(t, "/var/lib/app/certs", s.TLS.CertDir)
+	assert.Equal(t, "http://db.internal:9000", s.Backends["db"].URL)
+	assert.Equal(t, 0.75, s.Ratio2)
+
+	// a value which is already set is used as is, and so are the defaults of the fields referred to
+	s = RefStruct{MetricsPort: 1, Host: "example.com"}
+	_, err = SubsistuteDefaults(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://example.com:8080", s.PublicURL)
+	assert.Equal(t, 1, s.MetricsPort)
+}
+
+type RefCycleStruct struct {
+	A string `default:"${.B}"`
+	B string `default:"x${.C}"`
+	C string `default:"${.A}"`
+}
+
+type RefBadStruct struct {
+	Host string `default:"${.Hots}"`
+	Port int    `default:"${.Host+1}"`
+}
+
+func TestDefaultFieldRefErrors(t *testing.T) {
+	var c RefCycleStruct
+	_, err := SubsistuteDefaults(&c, nil)
+	assert.EqualError(t, err, "field A: default refers back to itself: A -> B -> C -> A")
+
+	// no cycle if one of them is set
+	c = RefCycleStruct{C: "c"}
+	_, err = SubsistuteDefaults(&c, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "xc", c.A)
+
+	var b RefBadStruct
+	_, err = SubsistuteDefaults(&b, &DefaultFieldSubstOpts{CollectErrors: true})
+	var me *MultiError
+	assert.ErrorAs(t, err, &me)
+	assert.Len(t, me.Errors, 2)
+	assert.EqualError(t, me.Errors[0], "field Host: Hots is not a field")
+	assert.EqualError(t, me.Errors[1], "field Port: Hots is not a field")
+}
+
+func TestProcessDefaultFieldRefsAfterEnv(t *testing.T) {
+	s := struct {
+		PublicURL string `default:"http://${.Host}:${.Port}"`
+		Host      string `env:"ZZ_HOST" default:"localhost"`
+		Port      int    `flag:"port" default:"80"`
+	}{}
+	t.Setenv("ZZ_HOST", "prod.example.com")
+	prov := Provenance{}
+	err := NewProcessor(nil).Process(&ConfTagOpts{
+		FlagTagOpts: &FlagFieldSubstOpts{UseFlags: flag.NewFlagSet("test", flag.ContinueOnError), Args: []string{"-port", "8443"}},
+		Provenance:  prov,
+	}, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://prod.example.com:8443", s.PublicURL)
+	assert.Equal(t, STAGEDEFAULT, prov["PublicURL"].Stage)
+}
+
+func TestDefaultFieldRefFuncCalledOnce(t *testing.T) {
+	calls := 0
+	p := NewProcessor(nil)
+	p.RegisterDefaultFunc("next", func(fieldname string) interface{} {
+		calls++
+		return string(rune('a' + calls - 1))
+	})
+	s := struct {
+		Name string `default:"svc-${.ID}"`
+		ID   string `default:"$(next)"`
+	}{}
+	_, err := p.SubsistuteDefaults(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "svc-a", s.Name)
+	assert.Equal(t, "a", s.ID)
+	assert.Equal(t, 1, calls)
+
+	// the same when the default refers to it after the env and flag stages
+	calls = 0
+	s.Name, s.ID = "", ""
+	err = p.Process(nil, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, "svc-a", s.Name)
+	assert.Equal(t, "a", s.ID)
+	assert.Equal(t, 1, calls)
+}
+
+func TestFieldRefsIntMapKeys(t *testing.T) {
+	s := struct {
+		M     map[int]string
+		Name  string `default:"${/M[3]}"`
+		Other string `test:"=.M[3]"`
+	}{M: map[int]string{3: "three"}, Other: "three"}
+	_, err := SubsistuteDefaults(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "three", s.Name)
+	_, err = RunTestFlags(&s, nil)
+	assert.Nil(t, err)
+
+	// an index which is not a key is not a field
+	s2 := struct {
+		M    map[int]string
+		Name string `default:"${/M[x]}"`
+	}{M: map[int]string{3: "three"}}
+	_, err = SubsistuteDefaults(&s2, nil)
+	assert.EqualError(t, err, "field Name: M[x] is not a field")
+}
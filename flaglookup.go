@@ -107,7 +107,15 @@ type FlagFieldSubstOpts struct {
 	Tags     *ProcessedFlagTags
 }
 
+// ProcessFlagTags adds the flags for all flag: tags in the struct to the flag set.
+// Uses the default Processor.
 func ProcessFlagTags(somestruct interface{}, opts *FlagFieldSubstOpts) (ret *ProcessedFlagTags, err error) {
+	return defaultProcessor.ProcessFlagTags(somestruct, opts)
+}
+
+// ProcessFlagTags adds the flags for all flag: tags in the struct to the flag set
+func (p *Processor) ProcessFlagTags(somestruct interface{}, opts *FlagFieldSubstOpts) (ret *ProcessedFlagTags, err error) {
+	conv := p.converters
 	ret = &ProcessedFlagTags{}
 	if opts == nil {
 		opts = &FlagFieldSubstOpts{}
@@ -132,7 +140,82 @@ func ProcessFlagTags(somestruct interface{}, opts *FlagFieldSubstOpts) (ret *Pro
 		myflags = flag.CommandLine
 	}
 
-	setFlagVal := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, usagetag string, existing *flagSetRetriever) (retriever *flagSetRetriever, err error) {
+	// setFlagConverted adds a flag for a field of a type parsed by convertString, i.e. time.Duration
+	setFlagConverted := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, usagetag string, layout string, existing *flagSetRetriever) (retriever *flagSetRetriever, err error) {
+		retrieverfunc := func(flagname string, r *flagSetRetriever) (err error) {
+			if r.touched {
+				v, ok := r.val.(reflect.Value)
+				if ok && v.Type() == fieldValue.Type() {
+					fieldValue.Set(v)
+				} else {
+					return fmt.Errorf("flag %s underlying interface{} type coercion failed", tag)
+				}
+			}
+			return nil
+		}
+		if existing != nil {
+			existing.retrievers = append(existing.retrievers, retrieverfunc)
+		} else {
+			retriever = &flagSetRetriever{fieldName: fieldName, fieldValue: fieldValue}
+			retriever.retrievers = append(retriever.retrievers, retrieverfunc)
+
+			myflags.Func(tag, usagetag, func(s string) error {
+				v, err := conv.convertString(fieldValue.Type(), s, layout)
+				if err != nil {
+					return err
+				}
+				ret.fieldsTouched = append(ret.fieldsTouched, addParentPath(parentpath, fieldName))
+				retriever.touched = true
+				retriever.val = v
+				return nil
+			})
+		}
+		return retriever, nil
+	}
+
+	// setFlagList adds a repeatable flag for a slice or map field, i.e. -peer a -peer b,c or -label a=1 -label b=2
+	setFlagList := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, usagetag string, layout string, confops map[string]string, existing *flagSetRetriever) (retriever *flagSetRetriever, err error) {
+		listval := conv.newListFlagValue(fieldValue.Type(), layout, confops)
+		if listval == nil {
+			return nil, fmt.Errorf("(flag) %s underlying type unsupported (setFlagList)", fieldValue.Type().String())
+		}
+		// the retrievers can be run more than once, but with conf:"append" the values must only be added once
+		var applied bool
+		retrieverfunc := func(flagname string, r *flagSetRetriever) (err error) {
+			if r.touched && !applied {
+				v, ok := r.val.(listFlagValue)
+				if !ok {
+					return fmt.Errorf("flag %s underlying interface{} type coercion failed", tag)
+				}
+				applied = v.apply(fieldValue)
+			}
+			return nil
+		}
+		if existing != nil {
+			existing.retrievers = append(existing.retrievers, retrieverfunc)
+		} else {
+			retriever = &flagSetRetriever{fieldName: fieldName, fieldValue: fieldValue}
+			retriever.retrievers = append(retriever.retrievers, retrieverfunc)
+			retriever.val = listval
+
+			myflags.Func(tag, usagetag, func(s string) error {
+				if !retriever.touched {
+					ret.fieldsTouched = append(ret.fieldsTouched, addParentPath(parentpath, fieldName))
+				}
+				retriever.touched = true
+				return listval.Set(s)
+			})
+		}
+		return retriever, nil
+	}
+
+	setFlagVal := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, usagetag string, layout string, confops map[string]string, existing *flagSetRetriever) (retriever *flagSetRetriever, err error) {
+		if conv.isConvertedType(fieldValue.Type()) {
+			return setFlagConverted(parentpath, fieldName, fieldValue, tag, usagetag, layout, existing)
+		}
+		if fieldValue.Kind() == reflect.Slice || conv.isMapType(fieldValue.Type()) {
+			return setFlagList(parentpath, fieldName, fieldValue, tag, usagetag, layout, confops, existing)
+		}
 		k := fieldValue.Kind()
 		switch k {
 		// TODO - add support for Ptr to String and Ints
@@ -279,7 +362,10 @@ func ProcessFlagTags(somestruct interface{}, opts *FlagFieldSubstOpts) (ret *Pro
 		return retriever, nil
 	}
 
-	setflagValPtr := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, usagetag string, existing *flagSetRetriever) (retriever *flagSetRetriever, err error) {
+	setflagValPtr := func(parentpath string, fieldName string, fieldValue reflect.Value, tag string, usagetag string, layout string, existing *flagSetRetriever) (retriever *flagSetRetriever, err error) {
+		if conv.isConvertedType(fieldValue.Elem().Type()) {
+			return setFlagConverted(parentpath, fieldName, fieldValue.Elem(), tag, usagetag, layout, existing)
+		}
 		k := fieldValue.Elem().Kind()
 		switch k {
 		// TODO - add support for Ptr to String and Ints
@@ -486,7 +572,7 @@ func ProcessFlagTags(somestruct interface{}, opts *FlagFieldSubstOpts) (ret *Pro
 					default:
 						debugf("flag: Got a NON-fundamental type: %s %s which is a %s\n", t.Kind().String(), t.Elem().String(), t.Elem().Kind().String())
 						if fieldValue.CanSet() {
-							if t.Elem().Kind() == reflect.Struct {
+							if t.Elem().Kind() == reflect.Struct || conv.isConvertedType(t.Elem()) {
 								fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
 							} else {
 								if len(tag) > 0 {
@@ -502,7 +588,7 @@ func ProcessFlagTags(somestruct interface{}, opts *FlagFieldSubstOpts) (ret *Pro
 
 				if !fieldValue.IsNil() {
 					// is this a Ptr to a struct?
-					if t.Elem().Kind() == reflect.Struct {
+					if conv.isStructType(t.Elem()) {
 						err := findFlags(addParentPath(parentpath, field.Name), fieldValue.Elem().Addr().Interface())
 						if err != nil {
 							return err
@@ -512,13 +598,13 @@ func ProcessFlagTags(somestruct interface{}, opts *FlagFieldSubstOpts) (ret *Pro
 						if len(tag) > 0 {
 							existing, ok := ret.needflags[tag] // check if we already have a retriever for this flag
 							if ok {
-								_, err = setflagValPtr(parentpath, field.Name, fieldValue, tag, usagetag, existing)
+								_, err = setflagValPtr(parentpath, field.Name, fieldValue, tag, usagetag, field.Tag.Get(LAYOUTFIELD), existing)
 								if err != nil {
 									return
 								}
 							} else {
 								var retriever *flagSetRetriever
-								retriever, err = setflagValPtr(parentpath, field.Name, fieldValue, tag, usagetag, nil)
+								retriever, err = setflagValPtr(parentpath, field.Name, fieldValue, tag, usagetag, field.Tag.Get(LAYOUTFIELD), nil)
 								if err != nil {
 									return
 								}
@@ -528,7 +614,7 @@ func ProcessFlagTags(somestruct interface{}, opts *FlagFieldSubstOpts) (ret *Pro
 
 					}
 				}
-			} else if field.Type.Kind() == reflect.Struct {
+			} else if conv.isStructType(field.Type) {
 				// recurse
 				fieldValue := inputValue.FieldByName(field.Name)
 				// is this a Ptr to a struct?
@@ -540,13 +626,13 @@ func ProcessFlagTags(somestruct interface{}, opts *FlagFieldSubstOpts) (ret *Pro
 				if len(tag) > 0 {
 					existing, ok := ret.needflags[tag] // check if we already have a retriever for this flag
 					if ok {
-						_, err = setFlagVal(parentpath, field.Name, fieldValue, tag, usagetag, existing)
+						_, err = setFlagVal(parentpath, field.Name, fieldValue, tag, usagetag, field.Tag.Get(LAYOUTFIELD), confops, existing)
 						if err != nil {
 							return
 						}
 					} else {
 						var retriever *flagSetRetriever
-						retriever, err = setFlagVal(parentpath, field.Name, fieldValue, tag, usagetag, nil)
+						retriever, err = setFlagVal(parentpath, field.Name, fieldValue, tag, usagetag, field.Tag.Get(LAYOUTFIELD), confops, nil)
 						if err != nil {
 							return
 						}
@@ -593,17 +679,23 @@ func FinalizeFlags(tags *ProcessedFlagTags) (err error) {
 }
 
 // This is a convenience function that run the FlagFieldSubstitution and then calls flag.Parse()
-// and then calls FinalizeFlags
+// and then calls FinalizeFlags. Uses the default Processor.
 func ProcessFlags(somestruct interface{}, opts *FlagFieldSubstOpts) (err error) {
+	return defaultProcessor.ProcessFlags(somestruct, opts)
+}
+
+// This is a convenience function that run the FlagFieldSubstitution and then calls flag.Parse()
+// and then calls FinalizeFlags
+func (p *Processor) ProcessFlags(somestruct interface{}, opts *FlagFieldSubstOpts) (err error) {
 	var processed *ProcessedFlagTags
 
-	_, ok := preprocessedStructFlags[somestruct]
+	_, ok := p.preprocessedStructFlags[somestruct]
 	if !ok {
-		processed, err = ProcessFlagTags(somestruct, opts)
+		processed, err = p.ProcessFlagTags(somestruct, opts)
 		if err != nil {
 			return
 		}
-		preprocessedStructFlags[somestruct] = processed
+		p.preprocessedStructFlags[somestruct] = processed
 	}
 	if opts == nil || opts.UseFlags == nil {
 		if !flag.Parsed() {
@@ -626,7 +718,7 @@ func ProcessFlags(somestruct interface{}, opts *FlagFieldSubstOpts) (err error)
 	// if err != nil {
 	// 	return
 	// }
-	for _, v := range preprocessedStructFlags {
+	for _, v := range p.preprocessedStructFlags {
 		err = FinalizeFlags(v)
 		if err != nil {
 			return
@@ -636,21 +728,27 @@ func ProcessFlags(somestruct interface{}, opts *FlagFieldSubstOpts) (err error)
 }
 
 // This is a convenience function that run the FlagFieldSubstitution and then calls flag.Parse()
-// and then calls FinalizeFlags - but with a flag.FlagSet passed in
+// and then calls FinalizeFlags - but with a flag.FlagSet passed in. Uses the default Processor.
 func ProcessFlagsWithFlagSet(somestruct interface{}, set *flag.FlagSet, argz []string) (err error) {
+	return defaultProcessor.ProcessFlagsWithFlagSet(somestruct, set, argz)
+}
+
+// This is a convenience function that run the FlagFieldSubstitution and then calls flag.Parse()
+// and then calls FinalizeFlags - but with a flag.FlagSet passed in
+func (p *Processor) ProcessFlagsWithFlagSet(somestruct interface{}, set *flag.FlagSet, argz []string) (err error) {
 	//	flagset := flag.NewFlagSet("test", flag.ExitOnError)
 	var processed *ProcessedFlagTags
-	_, ok := preprocessedStructFlags[somestruct]
+	_, ok := p.preprocessedStructFlags[somestruct]
 	if !ok {
-		processed, err = ProcessFlagTags(somestruct, &FlagFieldSubstOpts{
+		processed, err = p.ProcessFlagTags(somestruct, &FlagFieldSubstOpts{
 			UseFlags: set,
 		})
 		if err != nil {
 			return
 		}
-		preprocessedStructFlags[somestruct] = processed
+		p.preprocessedStructFlags[somestruct] = processed
 	}
-	// processed, err = ProcessFlagTags(somestruct, &FlagFieldSubstOpts{
+	// processed, err = p.ProcessFlagTags(somestruct, &FlagFieldSubstOpts{
 	// 	UseFlags: set,
 	// })
 	// if err != nil {
@@ -665,7 +763,7 @@ func ProcessFlagsWithFlagSet(somestruct interface{}, set *flag.FlagSet, argz []s
 	// if err != nil {
 	// 	return
 	// }
-	for _, v := range preprocessedStructFlags {
+	for _, v := range p.preprocessedStructFlags {
 		err = FinalizeFlags(v)
 		if err != nil {
 			return
@@ -674,24 +772,25 @@ func ProcessFlagsWithFlagSet(somestruct interface{}, set *flag.FlagSet, argz []s
 	return
 }
 
-var preprocessedStructFlags map[interface{}]*ProcessedFlagTags
-
-func init() {
-	preprocessedStructFlags = make(map[interface{}]*ProcessedFlagTags)
-}
-
 // Use this to add in all flags from a given struct *before* flags.Parse() is called anywhere.
 // flags.Parse could be handled by the caller later, OR it may be handled by the conftagz.Process()
-// function.
+// function. Uses the default Processor.
 func PreProcessFlagsWithFlagSet(somestruct interface{}, set *flag.FlagSet) (err error) {
+	return defaultProcessor.PreProcessFlagsWithFlagSet(somestruct, set)
+}
+
+// Use this to add in all flags from a given struct *before* flags.Parse() is called anywhere.
+// flags.Parse could be handled by the caller later, OR it may be handled by the Processor's
+// Process() method.
+func (p *Processor) PreProcessFlagsWithFlagSet(somestruct interface{}, set *flag.FlagSet) (err error) {
 	//	flagset := flag.NewFlagSet("test", flag.ExitOnError)
 	var processed *ProcessedFlagTags
-	processed, err = ProcessFlagTags(somestruct, &FlagFieldSubstOpts{
+	processed, err = p.ProcessFlagTags(somestruct, &FlagFieldSubstOpts{
 		UseFlags: set,
 	})
 	if err != nil {
 		return
 	}
-	preprocessedStructFlags[somestruct] = processed
+	p.preprocessedStructFlags[somestruct] = processed
 	return
 }
@@ -0,0 +1,178 @@
+package conftagz
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// InterpolateOpts are the options for the INTERPOLATE stage, which expands ${VAR}
+// in the string fields of the struct
+type InterpolateOpts struct {
+	// looks up a variable. os.LookupEnv if not set
+	Lookup func(name string) (string, bool)
+	// keep going if a field fails, and return a *MultiError with all the failures
+	CollectErrors bool
+	// the converters of the Processor running the stage. See EnvFieldSubstOpts
+	converters converterMap
+}
+
+// matchingBrace returns the index of the } closing the { before start, or -1
+func matchingBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isVarName(name string) bool {
+	if len(name) < 1 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isDotEnvNameChar(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// expandRef expands what is inside ${...}: VAR, VAR:-fallback or VAR:?message
+func expandRef(ref string, lookup func(name string) (string, bool)) (string, error) {
+	name := ref
+	var op, arg string
+	if n := strings.Index(ref, ":"); n >= 0 && n+1 < len(ref) && (ref[n+1] == '-' || ref[n+1] == '?') {
+		name, op, arg = ref[:n], ref[n:n+2], ref[n+2:]
+	}
+	if !isVarName(name) {
+		return "", fmt.Errorf("bad variable name in ${%s}", ref)
+	}
+	val, ok := lookup(name)
+	if ok && len(val) > 0 {
+		return val, nil
+	}
+	switch op {
+	case ":-":
+		// the fallback can have ${...} of its own
+		return interpolate(arg, lookup)
+	case ":?":
+		if len(arg) < 1 {
+			return "", fmt.Errorf("%s is not set", name)
+		}
+		return "", fmt.Errorf("%s: %s", name, arg)
+	}
+	return val, nil
+}
+
+// interpolate expands ${VAR}, ${VAR:-fallback} and ${VAR:?message} in s. A variable
+// which is not set is empty. $${ is a literal ${, and a $ not followed by { is left alone.
+func interpolate(s string, lookup func(name string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], "$${") {
+			b.WriteString("${")
+			i += 3
+			continue
+		}
+		if !strings.HasPrefix(s[i:], "${") {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		end := matchingBrace(s, i+2)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %s", s)
+		}
+		val, err := expandRef(s[i+2:end], lookup)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(val)
+		i = end + 1
+	}
+	return b.String(), nil
+}
+
+// InterpolateFields expands ${VAR}, ${VAR:-fallback} and ${VAR:?message} in every
+// string field of the struct, and in the strings of []string and map[string]string
+// fields. A field (or struct) tagged conf:"nointerp" is left alone.
+// It returns the paths of the fields which changed.
+func InterpolateFields(somestruct interface{}, opts *InterpolateOpts) (ret []string, err error) {
+	lookup := os.LookupEnv
+	var collect bool
+	var conv converterMap
+	if opts != nil {
+		if opts.Lookup != nil {
+			lookup = opts.Lookup
+		}
+		collect = opts.CollectErrors
+		conv = opts.converters
+	}
+	conv = conv.orDefault()
+	errs := newErrorCollector(STAGEINTERP, collect, somestruct)
+
+	// expand sets a string in place, returning true if it changed
+	expand := func(path string, s string, set func(string)) (bool, error) {
+		val, err := interpolate(s, lookup)
+		if err != nil {
+			return false, errs.add(path, s, s, err)
+		}
+		if val == s {
+			return false, nil
+		}
+		debugf("interpolate: Field %s %s -> %s\n", path, s, val)
+		set(val)
+		return true, nil
+	}
+
+	conv.walkLeafFields(somestruct, func(path string, leaf *leafField) {
+		if err != nil {
+			return
+		}
+		for _, field := range leaf.fields {
+			if noInterp(processConfTagOptsValues(field.Tag.Get(CONFFIELD))) {
+				return
+			}
+		}
+		v := leaf.value
+		var changed bool
+		switch {
+		case v.Kind() == reflect.String && v.CanSet():
+			changed, err = expand(path, v.String(), v.SetString)
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+			for n := 0; n < v.Len() && err == nil; n++ {
+				var c bool
+				c, err = expand(fmt.Sprintf("%s[%d]", path, n), v.Index(n).String(), v.Index(n).SetString)
+				changed = changed || c
+			}
+		case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String && v.Type().Elem().Kind() == reflect.String:
+			for _, key := range sortedMapKeys(v) {
+				if err != nil {
+					break
+				}
+				var c bool
+				c, err = expand(mapPath(path, key.String()), v.MapIndex(key).String(), func(s string) {
+					v.SetMapIndex(key, reflect.ValueOf(s).Convert(v.Type().Elem()))
+				})
+				changed = changed || c
+			}
+		}
+		if changed {
+			ret = append(ret, path)
+		}
+	})
+	return ret, errs.result(err)
+}
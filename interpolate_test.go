@@ -0,0 +1,115 @@
+package conftagz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpolate(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		val, ok := map[string]string{"HOST": "example.com", "PORT": "8443", "EMPTY": ""}[name]
+		return val, ok
+	}
+	for in, out := range map[string]string{
+		"https://${HOST}:${PORT}/api":   "https://example.com:8443/api",
+		"${MISSING}x":                   "x",
+		"${MISSING:-localhost}":         "localhost",
+		"${EMPTY:-fallback}":            "fallback",
+		"${MISSING:-${HOST}}":           "example.com",
+		"^[a-z]+$":                      "^[a-z]+$",
+		"$HOST ${HOST}":                 "$HOST example.com",
+		"$${HOST} is ${HOST}":           "${HOST} is example.com",
+		"cost: $5":                      "cost: $5",
+		"${PORT:?the port is required}": "8443",
+	} {
+		val, err := interpolate(in, lookup)
+		assert.Nil(t, err, in)
+		assert.Equal(t, out, val, in)
+	}
+	for in, msg := range map[string]string{
+		"${MISSING:?the port is required}": "MISSING: the port is required",
+		"${EMPTY:?}":                       "EMPTY is not set",
+		"${HOST":                           "unterminated ${ in ${HOST",
+		"${1HOST}":                         "bad variable name in ${1HOST}",
+	} {
+		_, err := interpolate(in, lookup)
+		assert.EqualError(t, err, msg, in)
+	}
+}
+
+type InterpBackend struct {
+	URL string `yaml:"url"`
+}
+
+type InterpStruct struct {
+	URL      string                    `yaml:"url"`
+	Regex    string                    `yaml:"regex" conf:"nointerp"`
+	Peers    []string                  `yaml:"peers"`
+	Labels   map[string]string         `yaml:"labels"`
+	Name     *string                   `yaml:"name"`
+	Port     int                       `yaml:"port"`
+	Backends map[string]*InterpBackend `yaml:"backends"`
+	Raw      *InterpBackend            `yaml:"raw" conf:"nointerp"`
+}
+
+func TestInterpolateFields(t *testing.T) {
+	t.Setenv("INTERP_HOST", "example.com")
+	name := "${INTERP_HOST}"
+	s := InterpStruct{
+		URL:      "https://${INTERP_HOST}/api",
+		Regex:    "^${INTERP_HOST}$",
+		Peers:    []string{"a", "${INTERP_HOST}"},
+		Labels:   map[string]string{"host": "${INTERP_HOST}"},
+		Name:     &name,
+		Backends: map[string]*InterpBackend{"primary": {URL: "${INTERP_HOST}:1"}},
+		Raw:      &InterpBackend{URL: "${INTERP_HOST}"},
+	}
+	touched, err := InterpolateFields(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"URL", "Peers", "Labels", "Name", "Backends[primary].URL"}, touched)
+	assert.Equal(t, "https://example.com/api", s.URL)
+	assert.Equal(t, "^${INTERP_HOST}$", s.Regex)
+	assert.Equal(t, []string{"a", "example.com"}, s.Peers)
+	assert.Equal(t, map[string]string{"host": "example.com"}, s.Labels)
+	assert.Equal(t, "example.com", *s.Name)
+	assert.Equal(t, "example.com:1", s.Backends["primary"].URL)
+	assert.Equal(t, "${INTERP_HOST}", s.Raw.URL)
+}
+
+func TestInterpolateErrors(t *testing.T) {
+	s := InterpStruct{URL: "${INTERP_NOPE:?url needs INTERP_NOPE}", Peers: []string{"${INTERP_NOPE:?}"}}
+	_, err := InterpolateFields(&s, nil)
+	assert.EqualError(t, err, "field URL: INTERP_NOPE: url needs INTERP_NOPE")
+	var fe *FieldError
+	assert.ErrorAs(t, err, &fe)
+	assert.Equal(t, STAGEINTERP, fe.Stage)
+	assert.Equal(t, "url", fe.Key)
+
+	_, err = InterpolateFields(&s, &InterpolateOpts{CollectErrors: true})
+	var me *MultiError
+	assert.ErrorAs(t, err, &me)
+	assert.Len(t, me.Errors, 2)
+	assert.EqualError(t, me.Errors[1], "field Peers[0]: INTERP_NOPE is not set")
+}
+
+type InterpOrderStruct struct {
+	Host string `yaml:"host" env:"INTERP_ORDER_HOST" conf:"order=file,env"`
+}
+
+func TestProcessInterpolate(t *testing.T) {
+	t.Setenv("INTERP_DOMAIN", "example.com")
+	t.Setenv("INTERP_ORDER_HOST", "env.example.com")
+	s := InterpOrderStruct{Host: "api.${INTERP_DOMAIN}"}
+	prov := Provenance{}
+	err := NewProcessor(nil).Process(&ConfTagOpts{InterpOpts: &InterpolateOpts{}, Provenance: prov}, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, "api.example.com", s.Host)
+	assert.Equal(t, STAGEFILE, prov["Host"].Stage)
+
+	// without InterpOpts nothing is expanded
+	s = InterpOrderStruct{Host: "api.${INTERP_DOMAIN}"}
+	err = NewProcessor(nil).Process(nil, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, "api.${INTERP_DOMAIN}", s.Host)
+}
@@ -0,0 +1,205 @@
+package conftagz
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// isMapType is true for maps with string keys, which are the only maps conftagz
+// knows how to fill in, i.e. map[string]string or map[string]*Backend
+func (c converterMap) isMapType(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String && !c.isConvertedType(t)
+}
+
+// isMapOfStructs is true if the values of the map are structs, or pointers to structs,
+// which should be walked into
+func (c converterMap) isMapOfStructs(t reflect.Type) bool {
+	return c.isMapType(t) && c.isStructOrPtrToStruct(t.Elem())
+}
+
+// mapPath is the path of the value at key in the map field at path, i.e. "Backends[primary]"
+func mapPath(path string, key string) string {
+	return fmt.Sprintf("%s[%s]", path, key)
+}
+
+// convertScalar parses s into a value of type t, which is a string, bool, number
+// or a type where isConvertedType is true
+func (c converterMap) convertScalar(t reflect.Type, s string, layout string) (ret reflect.Value, err error) {
+	if c.isConvertedType(t) {
+		return c.convertString(t, s, layout)
+	}
+	ret = reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		ret.SetString(s)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		if err != nil {
+			err = fmt.Errorf("not a bool")
+			return
+		}
+		ret.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			err = fmt.Errorf("not a number")
+			return
+		}
+		ret.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			err = fmt.Errorf("not a number")
+			return
+		}
+		ret.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		var n float64
+		n, err = strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			err = fmt.Errorf("not a number")
+			return
+		}
+		ret.SetFloat(n)
+	default:
+		err = fmt.Errorf("no conversion from string to %s", t.String())
+	}
+	return
+}
+
+// setMapEntries parses a list of key=value pairs like "a=1,b=2" and sets them in m,
+// which must not be nil. sep separates the pairs.
+func (c converterMap) setMapEntries(m reflect.Value, s string, sep string, layout string) error {
+	for _, entry := range splitList(s, sep) {
+		entry = strings.TrimSpace(entry)
+		if len(entry) < 1 {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || len(strings.TrimSpace(kv[0])) < 1 {
+			return fmt.Errorf("%s is not key=value", entry)
+		}
+		key := strings.TrimSpace(kv[0])
+		val, err := c.convertScalar(m.Type().Elem(), strings.TrimSpace(kv[1]), layout)
+		if err != nil {
+			return fmt.Errorf("%s %s", key, err.Error())
+		}
+		m.SetMapIndex(reflect.ValueOf(key).Convert(m.Type().Key()), val)
+	}
+	return nil
+}
+
+// parseMap returns a new map of type t holding the key=value pairs in s
+func (c converterMap) parseMap(t reflect.Type, s string, sep string, layout string) (reflect.Value, error) {
+	m := reflect.MakeMap(t)
+	err := c.setMapEntries(m, s, sep, layout)
+	return m, err
+}
+
+// sortedMapKeys returns the keys of the map in order, so maps are always
+// walked the same way
+func sortedMapKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+// walkMapStructs calls f with the path and a pointer to each struct in a map of structs
+// or pointers to structs. Map values can not be changed in place, so a struct value is
+// copied out and stored back once f returns. Nil pointers are skipped.
+func walkMapStructs(path string, m reflect.Value, f func(path string, somestruct interface{}) error) error {
+	for _, key := range sortedMapKeys(m) {
+		val := m.MapIndex(key)
+		itempath := mapPath(path, key.String())
+		if val.Kind() == reflect.Ptr {
+			if val.IsNil() {
+				continue
+			}
+			if err := f(itempath, val.Interface()); err != nil {
+				return err
+			}
+			continue
+		}
+		ptr := reflect.New(val.Type())
+		ptr.Elem().Set(val)
+		err := f(itempath, ptr.Interface())
+		m.SetMapIndex(key, ptr.Elem())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapFlagValue is a flag.Value (and a pflag.Value) for a map field. The flag can
+// be repeated, i.e. --label a=1 --label b=2, and each use can also hold a list, i.e. --label a=1,b=2
+type mapFlagValue struct {
+	conv   converterMap
+	t      reflect.Type
+	layout string
+	sep    string
+	val    reflect.Value
+	set    bool
+	// the entries are added to the field rather than replacing it (conf:"append")
+	append bool
+}
+
+func (v *mapFlagValue) String() string {
+	if v == nil || !v.set {
+		return ""
+	}
+	var entries []string
+	for _, key := range sortedMapKeys(v.val) {
+		entries = append(entries, fmt.Sprintf("%s=%v", key.String(), v.val.MapIndex(key).Interface()))
+	}
+	sep := v.sep
+	if len(sep) < 1 {
+		sep = DEFAULTSEP
+	}
+	return strings.Join(entries, sep)
+}
+
+func (v *mapFlagValue) Set(s string) error {
+	if !v.set {
+		v.val = reflect.MakeMap(v.t)
+		v.set = true
+	}
+	return v.conv.setMapEntries(v.val, s, v.sep, v.layout)
+}
+
+func (v *mapFlagValue) Type() string {
+	return "key=value"
+}
+
+// apply sets the field to the entries of the flag, or adds them to it. It
+// returns false if the flag was not used.
+func (v *mapFlagValue) apply(fieldValue reflect.Value) bool {
+	if !v.set {
+		return false
+	}
+	if !v.append {
+		fieldValue.Set(v.val)
+		return true
+	}
+	mergeMap(fieldValue, v.val)
+	return true
+}
+
+// mergeMap sets every entry of from in the map field, creating the map if it is nil
+func mergeMap(fieldValue reflect.Value, from reflect.Value) {
+	if fieldValue.IsNil() {
+		fieldValue.Set(reflect.MakeMapWithSize(fieldValue.Type(), from.Len()))
+	}
+	iter := from.MapRange()
+	for iter.Next() {
+		fieldValue.SetMapIndex(iter.Key(), iter.Value())
+	}
+}
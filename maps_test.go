@@ -0,0 +1,178 @@
+package conftagz
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+type MapBackend struct {
+	Host string `yaml:"host" default:"localhost"`
+	Port int    `yaml:"port" env:"MAP_BACKEND_PORT" default:"80" test:">0,<65536"`
+}
+
+type MapStruct struct {
+	Labels   map[string]string        `yaml:"labels" env:"MAP_LABELS" default:"tier=web,team=core" flag:"label"`
+	Limits   map[string]int           `yaml:"limits" env:"MAP_LIMITS" flag:"limit"`
+	Timeouts map[string]time.Duration `yaml:"timeouts" default:"read=5s,write=10s"`
+	Backends map[string]*MapBackend   `yaml:"backends"`
+	Replicas map[string]MapBackend    `yaml:"replicas"`
+}
+
+func TestMapDefaults(t *testing.T) {
+	s := MapStruct{
+		Backends: map[string]*MapBackend{"primary": {Port: 8080}, "secondary": {Host: "db2"}},
+		Replicas: map[string]MapBackend{"east": {}},
+	}
+	touched, err := SubsistuteDefaults(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"tier": "web", "team": "core"}, s.Labels)
+	assert.Nil(t, s.Limits)
+	assert.Equal(t, map[string]time.Duration{"read": 5 * time.Second, "write": 10 * time.Second}, s.Timeouts)
+	assert.Equal(t, MapBackend{Host: "localhost", Port: 8080}, *s.Backends["primary"])
+	assert.Equal(t, MapBackend{Host: "db2", Port: 80}, *s.Backends["secondary"])
+	assert.Equal(t, MapBackend{Host: "localhost", Port: 80}, s.Replicas["east"])
+	assert.Contains(t, touched, "Backends[secondary].Port")
+	assert.Contains(t, touched, "Replicas[east].Host")
+
+	// a map from the config file is left alone
+	s = MapStruct{Labels: map[string]string{"tier": "db"}}
+	_, err = SubsistuteDefaults(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"tier": "db"}, s.Labels)
+
+	_, err = SubsistuteDefaults(&struct {
+		M map[string]int `default:"a=1,b=two"`
+	}{}, nil)
+	assert.EqualError(t, err, "field M: default value a=1,b=two b not a number")
+	_, err = SubsistuteDefaults(&struct {
+		M map[string]int `default:"a"`
+	}{}, nil)
+	assert.EqualError(t, err, "field M: default value a a is not key=value")
+}
+
+func TestMapDefaultFunc(t *testing.T) {
+	p := NewProcessor(nil)
+	p.RegisterDefaultFunc("backends", func(fieldname string) interface{} {
+		return map[string]*MapBackend{"primary": {Host: "db1"}}
+	})
+	s := struct {
+		Backends map[string]*MapBackend `default:"$(backends)"`
+	}{}
+	_, err := p.SubsistuteDefaults(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, MapBackend{Host: "db1", Port: 80}, *s.Backends["primary"])
+}
+
+func TestMapEnv(t *testing.T) {
+	s := MapStruct{
+		Labels:   map[string]string{"tier": "db"},
+		Backends: map[string]*MapBackend{"primary": {}},
+		Replicas: map[string]MapBackend{"east": {}},
+	}
+	m := map[string]string{
+		"MAP_LABELS":       "team=infra, zone=a",
+		"MAP_LIMITS":       "cpu=2,mem=512",
+		"MAP_BACKEND_PORT": "5432",
+	}
+	touched, err := EnvFieldSubstitutionFromMap(&s, nil, m)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"Labels", "Limits", "Backends[primary].Port", "Replicas[east].Port"}, touched)
+	assert.Equal(t, map[string]string{"team": "infra", "zone": "a"}, s.Labels)
+	assert.Equal(t, map[string]int{"cpu": 2, "mem": 512}, s.Limits)
+	assert.Equal(t, 5432, s.Backends["primary"].Port)
+	assert.Equal(t, 5432, s.Replicas["east"].Port)
+
+	_, err = EnvFieldSubstitutionFromMap(&s, nil, map[string]string{"MAP_LIMITS": "cpu=lots"})
+	assert.EqualError(t, err, "field Limits: map (env) MAP_LIMITS value cpu=lots cpu not a number")
+}
+
+func TestMapFlags(t *testing.T) {
+	s := MapStruct{Labels: map[string]string{"tier": "db"}}
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := NewProcessor(nil).ProcessFlags(&s, &FlagFieldSubstOpts{
+		UseFlags: flagset,
+		Args:     []string{"-label", "team=infra", "-label", "zone=a,rack=4", "-limit", "cpu=2"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"team": "infra", "zone": "a", "rack": "4"}, s.Labels)
+	assert.Equal(t, map[string]int{"cpu": 2}, s.Limits)
+
+	var s2 MapStruct
+	flagset = flag.NewFlagSet("test", flag.ContinueOnError)
+	err = NewProcessor(nil).ProcessFlags(&s2, &FlagFieldSubstOpts{
+		UseFlags: flagset,
+		Args:     []string{"-limit", "cpu"},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestMapCobra(t *testing.T) {
+	s := struct {
+		Labels map[string]string `cflag:"label,l" cobra:"root"`
+	}{}
+	rootCmd := &cobra.Command{Use: "app"}
+	p := NewProcessor(nil)
+	p.RegisterCobraCmd("root", rootCmd)
+	err := p.PreProcessCobraFlags(&s, nil)
+	assert.Nil(t, err)
+	err = rootCmd.ParseFlags([]string{"-l", "a=1", "--label", "b=2"})
+	assert.Nil(t, err)
+	err = p.PostProcessCobraFlags()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, s.Labels)
+}
+
+func TestMapTests(t *testing.T) {
+	s := MapStruct{
+		Backends: map[string]*MapBackend{"primary": {Port: 5432}, "secondary": {Port: 70000}},
+	}
+	_, err := RunTestFlags(&s, nil)
+	assert.EqualError(t, err, "field Backends[secondary].Port: value 70000 ! < 65536")
+	var fe *FieldError
+	assert.ErrorAs(t, err, &fe)
+	assert.Equal(t, "backends[secondary].port", fe.Key)
+
+	s = MapStruct{Replicas: map[string]MapBackend{"east": {Port: 0}}}
+	_, err = RunTestFlags(&s, nil)
+	assert.EqualError(t, err, "field Replicas[east].Port: value 0 ! > 0")
+}
+
+func TestMapProcess(t *testing.T) {
+	yml := `
+labels:
+  tier: db
+backends:
+  primary:
+    host: db1
+  secondary:
+    port: 0
+`
+	var s MapStruct
+	err := yaml.Unmarshal([]byte(yml), &s)
+	assert.Nil(t, err)
+
+	prov := Provenance{}
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	err = NewProcessor(nil).Process(&ConfTagOpts{
+		FlagTagOpts: &FlagFieldSubstOpts{UseFlags: flagset, Args: []string{"-limit", "cpu=4"}},
+		Provenance:  prov,
+	}, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"tier": "db"}, s.Labels)
+	assert.Equal(t, map[string]int{"cpu": 4}, s.Limits)
+	assert.Equal(t, "db1", s.Backends["primary"].Host)
+	assert.Equal(t, 80, s.Backends["secondary"].Port)
+	assert.Equal(t, STAGEDEFAULT, prov["Backends[secondary].Port"].Stage)
+	assert.Equal(t, STAGEFLAG, prov["Limits"].Stage)
+	assert.Equal(t, STAGEINPUT, prov["Labels"].Stage)
+
+	present, err := FindPresentKeys([]byte(yml), FORMATYAML)
+	assert.Nil(t, err)
+	assert.True(t, present.Has("backends[secondary].port"))
+	assert.False(t, present.Has("backends[primary].port"))
+}
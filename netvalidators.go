@@ -0,0 +1,158 @@
+package conftagz
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// builtinTestFuncs are the TestFuncs every Processor starts with, i.e. test:"$(ip)".
+// A TestFunc registered with the same name replaces one.
+func builtinTestFuncs() map[string]TestFunc {
+	return map[string]TestFunc{
+		"ip":       stringTest(isIP),
+		"ipv4":     stringTest(isIPv4),
+		"ipv6":     stringTest(isIPv6),
+		"cidr":     stringTest(isCIDR),
+		"hostname": stringTest(isHostname),
+		"hostport": stringTest(isHostPort),
+		"port":     isPort,
+		"url":      urlTest(""),
+	}
+}
+
+// testFuncsWithArg make the built in TestFuncs which take an argument after a :,
+// i.e. $(url:https) or $(url:http|https)
+var testFuncsWithArg = map[string]func(arg string) TestFunc{
+	"url": urlTest,
+}
+
+// lookupTestFunc finds the TestFunc for $(name), which may be one of testFuncsWithArg
+func lookupTestFunc(testFuncs map[string]TestFunc, name string) TestFunc {
+	if f := testFuncs[name]; f != nil {
+		return f
+	}
+	if base, arg, ok := strings.Cut(name, ":"); ok && testFuncsWithArg[base] != nil {
+		return testFuncsWithArg[base](arg)
+	}
+	return nil
+}
+
+// stringTest makes a TestFunc for string fields, or types with a String method
+func stringTest(f func(s string) bool) TestFunc {
+	return func(val interface{}, fieldname string) bool {
+		switch v := val.(type) {
+		case string:
+			return f(v)
+		case fmt.Stringer:
+			return f(v.String())
+		}
+		return false
+	}
+}
+
+func isIP(s string) bool {
+	_, err := netip.ParseAddr(s)
+	return err == nil
+}
+
+func isIPv4(s string) bool {
+	addr, err := netip.ParseAddr(s)
+	return err == nil && addr.Is4()
+}
+
+func isIPv6(s string) bool {
+	addr, err := netip.ParseAddr(s)
+	return err == nil && addr.Is6()
+}
+
+func isCIDR(s string) bool {
+	_, err := netip.ParsePrefix(s)
+	return err == nil
+}
+
+// isHostname is true for a DNS name as in RFC 1123, i.e. db-1.example.com. A name
+// whose last label is all digits is not one, so 999.1.1.1 is not taken for a name.
+func isHostname(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if len(s) < 1 || len(s) > 253 {
+		return false
+	}
+	labels := strings.Split(s, ".")
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return false
+	}
+	for _, label := range labels {
+		if len(label) < 1 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isHostPort is true for host:port, [ipv6]:port or :port. The host is a
+// hostname or an IP and the port a number.
+func isHostPort(s string) bool {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return false
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return false
+	}
+	return len(host) < 1 || isIP(host) || isHostname(host)
+}
+
+// isPort is true for a number from 1 to 65535, in a number or string field
+func isPort(val interface{}, fieldname string) bool {
+	var n int64
+	switch v := val.(type) {
+	case int64:
+		n = v
+	case uint64:
+		if v > 65535 {
+			return false
+		}
+		n = int64(v)
+	case string:
+		var err error
+		if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return false
+		}
+	default:
+		return false
+	}
+	return n >= 1 && n <= 65535
+}
+
+// urlTest makes a TestFunc for an absolute URL with a host. schemes is the
+// schemes allowed, separated by |, or any if empty.
+func urlTest(schemes string) TestFunc {
+	return stringTest(func(s string) bool {
+		u, err := url.Parse(s)
+		if err != nil || len(u.Scheme) < 1 || len(u.Host) < 1 {
+			return false
+		}
+		if host := u.Hostname(); !isIP(host) && !isHostname(host) {
+			return false
+		}
+		if len(schemes) < 1 {
+			return true
+		}
+		for _, scheme := range strings.Split(schemes, "|") {
+			if strings.EqualFold(u.Scheme, scheme) {
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,99 @@
+package conftagz
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetValidators(t *testing.T) {
+	tests := []struct {
+		name string
+		val  interface{}
+		ok   bool
+	}{
+		{"ip", "10.0.0.1", true},
+		{"ip", "::1", true},
+		{"ip", "999.1.1.1", false},
+		{"ip", netip.MustParseAddr("10.0.0.1"), true},
+		{"ip", net.ParseIP("10.0.0.1"), true},
+		{"ipv4", "10.0.0.1", true},
+		{"ipv4", "::1", false},
+		{"ipv6", "fe80::1", true},
+		{"ipv6", "10.0.0.1", false},
+		{"cidr", "10.0.0.0/8", true},
+		{"cidr", "10.0.0.0/33", false},
+		{"cidr", "10.0.0.0", false},
+		{"hostname", "db-1.example.com", true},
+		{"hostname", "example.com.", true},
+		{"hostname", "-bad.example.com", false},
+		{"hostname", "under_score.com", false},
+		{"hostname", "", false},
+		{"hostname", "999.1.1.1", false},
+		{"hostname", "1.example.com", true},
+		{"hostport", "db.example.com:5432", true},
+		{"hostport", "[::1]:443", true},
+		{"hostport", ":8080", true},
+		{"hostport", "db.example.com", false},
+		{"hostport", "db.example.com:99999", false},
+		{"hostport", "bad host:80", false},
+		{"hostport", "999.1.1.1:80", false},
+		{"hostport", "10.0.0.1:80", true},
+		{"port", int64(443), true},
+		{"port", int64(0), false},
+		{"port", uint64(70000), false},
+		{"port", "8080", true},
+		{"port", "http", false},
+		{"url", "https://example.com/hook", true},
+		{"url", "example.com/hook", false},
+		{"url", "/just/a/path", false},
+		{"url", "http://999.1.1.1/", false},
+		{"url", "http://bad_host!/", false},
+		{"url", "http://10.0.0.1:8080/", true},
+		{"url", "http://[::1]/", true},
+		{"url:https", "https://example.com", true},
+		{"url:https", "http://example.com", false},
+		{"url:http|https", "http://example.com", true},
+		{"url:http|https", "ftp://example.com", false},
+	}
+	p := NewProcessor(nil)
+	for _, tt := range tests {
+		f := lookupTestFunc(p.testFuncs, tt.name)
+		if assert.NotNil(t, f, tt.name) {
+			assert.Equal(t, tt.ok, f(tt.val, "F"), "%s %v", tt.name, tt.val)
+		}
+	}
+	assert.Nil(t, lookupTestFunc(p.testFuncs, "nosuch:arg"))
+}
+
+func TestNetValidatorsInStruct(t *testing.T) {
+	s := struct {
+		IP      string   `test:"$(ipv4)"`
+		Port    uint16   `test:"$(port)"`
+		Hook    string   `test:"$(url:https)"`
+		Servers []string `test:"each($(hostport))"`
+		Nets    []string `test:"each($(cidr))"`
+	}{IP: "10.0.0.1", Port: 8080, Hook: "https://example.com", Servers: []string{"a:1"}, Nets: []string{"10.0.0.0/8"}}
+	p := NewProcessor(nil)
+	_, err := p.RunTestFlags(&s, nil)
+	assert.Nil(t, err)
+
+	s.IP = "999.1.1.1"
+	s.Port = 0
+	s.Hook = "http://example.com"
+	s.Servers = []string{"a:1", "b"}
+	_, err = p.RunTestFlags(&s, &TestFieldSubstOpts{CollectErrors: true})
+	assert.EqualError(t, err, "4 errors:\n"+
+		"\ttest: field IP: value 999.1.1.1 !$(ipv4)\n"+
+		"\ttest: field Port: value 0 !$(port)\n"+
+		"\ttest: field Hook: value http://example.com !$(url:https)\n"+
+		"\ttest: field Servers[1]: value b !$(hostport)")
+
+	// a registered TestFunc replaces a built in one
+	p.RegisterTestFunc("ipv4", func(val interface{}, fieldname string) bool { return true })
+	s.Port, s.Hook, s.Servers = 80, "https://example.com", nil
+	_, err = p.RunTestFlags(&s, nil)
+	assert.Nil(t, err)
+}
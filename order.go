@@ -0,0 +1,190 @@
+package conftagz
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ORDEROPT is the conf: option giving a field its own precedence of sources,
+// highest first, i.e. conf:"order=file,env,flag,default"
+const ORDEROPT = "order"
+
+// the sources which can be listed in a conf:"order=..." tag. They are named
+// after the stages which set them.
+var orderSources = []string{STAGEFILE, STAGEDIR, STAGEENV, STAGEFLAG, STAGEDEFAULT}
+
+func isOrderSource(name string) bool {
+	for _, source := range orderSources {
+		if name == source {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrder returns an error if the order can not be followed, or contradicts
+// the other conf: options of the field
+func checkOrder(order []string, confops map[string]string) error {
+	seen := make(map[string]bool)
+	for n, source := range order {
+		if !isOrderSource(source) {
+			return fmt.Errorf("unknown source %s in order (expected one of %s)", source, strings.Join(orderSources, ","))
+		}
+		if seen[source] {
+			return fmt.Errorf("source %s is in the order more than once", source)
+		}
+		seen[source] = true
+		// a default is only used when the field is still zero, so it can not beat anything
+		if source == STAGEDEFAULT && n != len(order)-1 {
+			return fmt.Errorf("default must be last in the order")
+		}
+	}
+	if preferEnv(confops) && seen[STAGEENV] && order[0] != STAGEENV {
+		return fmt.Errorf("conf:preferenv contradicts the order, env must be first")
+	}
+	if envSkip(confops) && seen[STAGEENV] {
+		return fmt.Errorf("conf:envskip contradicts the order, which has env")
+	}
+	if defaultSkip(confops) && seen[STAGEDEFAULT] {
+		return fmt.Errorf("conf:defaultskip contradicts the order, which has default")
+	}
+	return nil
+}
+
+// hasOrderTags is true if any field of the type, or of the structs it holds,
+// has a conf:"order=..." tag
+func (c converterMap) hasOrderTags(t reflect.Type, seen map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if !c.isStructType(t) || seen[t] {
+		return false
+	}
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if _, ok := processConfTagOptsValues(field.Tag.Get(CONFFIELD))[ORDEROPT]; ok {
+			return true
+		}
+		if c.hasOrderTags(field.Type, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// orderedField is the value each source gave a field with a conf:"order=..." tag
+type orderedField struct {
+	order  []string
+	values map[string]interface{}
+	// the value after the last stage, and the source of it
+	last interface{}
+	from string
+}
+
+// orderTracker records the value each stage gives the fields with a conf:"order=..." tag,
+// so once all the stages have run each field can be set to the value from its
+// highest precedence source
+type orderTracker struct {
+	conv       converterMap
+	somestruct interface{}
+	fields     map[string]*orderedField
+	err        error
+}
+
+// newOrderTracker returns nil if the struct has no conf:"order=..." tags. Any
+// value already in the struct is counted as coming from the config file.
+func newOrderTracker(conv converterMap, somestruct interface{}) (*orderTracker, error) {
+	if !conv.hasOrderTags(reflect.TypeOf(somestruct), make(map[reflect.Type]bool)) {
+		return nil, nil
+	}
+	o := &orderTracker{conv: conv, somestruct: somestruct, fields: make(map[string]*orderedField)}
+	o.update(STAGEFILE, nil)
+	return o, o.err
+}
+
+// update records the fields the stage set, which are the touched ones, even if the value is
+// the one the field already had. If the stage gives no touched list, the fields it changed
+// are used. A value changed by the INTERPOLATE stage still belongs to the source which set it.
+func (o *orderTracker) update(stage string, touched []string) {
+	touchedmap := make(map[string]bool)
+	for _, path := range touched {
+		touchedmap[path] = true
+	}
+	o.conv.walkLeafFields(o.somestruct, func(path string, leaf *leafField) {
+		f, ok := o.fields[path]
+		if !ok {
+			confops := processConfTagOptsValues(leaf.fields[0].Tag.Get(CONFFIELD))
+			order := fieldOrder(confops)
+			if order == nil {
+				return
+			}
+			if err := checkOrder(order, confops); err != nil {
+				if o.err == nil {
+					o.err = &FieldError{Path: path, Tag: confops[ORDEROPT], Err: err}
+				}
+				return
+			}
+			// the field is new, i.e. a nil pointer was filled in, so it was zero before
+			f = &orderedField{order: order, values: make(map[string]interface{}), last: reflect.Zero(leaf.value.Type()).Interface(), from: STAGEFILE}
+			o.fields[path] = f
+		}
+		val := snapshotValue(leaf.value)
+		if touchedmap[path] || !reflect.DeepEqual(f.last, val) {
+			if stage != STAGEINTERP {
+				f.from = stage
+			}
+			f.values[f.from] = val
+			f.last = val
+		}
+	})
+}
+
+// resolve sets each field to the value from the first source in its order which
+// gave it one, or to zero if none did. Fields are set one source at a time, and
+// after each source done is called with it, so provenance can be recorded.
+// The source is STAGEINPUT for the fields which were set to zero.
+// It returns the paths of the fields set to zero which have default in their order,
+// as the default stage skipped them if another source had set them by then.
+func (o *orderTracker) resolve(done func(source string)) (unset map[string]bool, err error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+	unset = make(map[string]bool)
+	for _, source := range append([]string{STAGEINPUT}, orderSources...) {
+		var changed bool
+		o.conv.walkLeafFields(o.somestruct, func(path string, leaf *leafField) {
+			f, ok := o.fields[path]
+			if !ok {
+				return
+			}
+			from := STAGEINPUT
+			val := reflect.Zero(leaf.value.Type()).Interface()
+			for _, s := range f.order {
+				if v, ok := f.values[s]; ok {
+					from = s
+					val = v
+					break
+				}
+			}
+			if from != source {
+				return
+			}
+			if from == STAGEINPUT && f.order[len(f.order)-1] == STAGEDEFAULT {
+				unset[path] = true
+			}
+			if reflect.DeepEqual(snapshotValue(leaf.value), val) {
+				return
+			}
+			debugf("order: Field %s set from %s\n", path, from)
+			leaf.value.Set(reflect.ValueOf(val))
+			f.last = val
+			changed = true
+		})
+		if changed && done != nil {
+			done(source)
+		}
+	}
+	return unset, nil
+}
@@ -0,0 +1,131 @@
+package conftagz
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type OrderStruct struct {
+	Host   string `yaml:"host" env:"ORDER_HOST" flag:"host" default:"localhost" conf:"order=file,env,flag,default"`
+	Secret string `yaml:"secret" env:"ORDER_SECRET" flag:"secret" conf:"order=env,file"`
+	Port   int    `yaml:"port" env:"ORDER_PORT" flag:"port" default:"80"`
+	Inner  *OrderInner
+}
+
+type OrderInner struct {
+	Name string `env:"ORDER_NAME" flag:"name" conf:"order=flag"`
+}
+
+func TestProcessFieldOrder(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfFile(t, dir, "conf.yaml", "host: filehost\nsecret: filesecret\nport: 1\n")
+	t.Setenv("ORDER_HOST", "envhost")
+	t.Setenv("ORDER_SECRET", "envsecret")
+	t.Setenv("ORDER_PORT", "2")
+	t.Setenv("ORDER_NAME", "envname")
+
+	var s OrderStruct
+	prov := Provenance{}
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := NewProcessor(nil).Process(&ConfTagOpts{
+		FileOpts: &ConfigFileOpts{Paths: []string{path}},
+		FlagTagOpts: &FlagFieldSubstOpts{
+			UseFlags: flagset,
+			Args:     []string{"-host", "flaghost", "-secret", "flagsecret", "-port", "3"},
+		},
+		Provenance: prov,
+	}, &s)
+	assert.Nil(t, err)
+	// the file beats the env var and flag
+	assert.Equal(t, "filehost", s.Host)
+	assert.Equal(t, STAGEFILE, prov["Host"].Stage)
+	assert.Equal(t, path, prov["Host"].Key)
+	// the flag is not in the order, so never used
+	assert.Equal(t, "envsecret", s.Secret)
+	assert.Equal(t, STAGEENV, prov["Secret"].Stage)
+	// no order, so the usual flag wins
+	assert.Equal(t, 3, s.Port)
+	// only a flag may set the field, and there was none
+	assert.Equal(t, "", s.Inner.Name)
+}
+
+func TestProcessFieldOrderDefault(t *testing.T) {
+	var s OrderStruct
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := NewProcessor(nil).Process(&ConfTagOpts{
+		OrderOfOps:  []int{DEFAULTTAGS, FLAGTAGS},
+		FlagTagOpts: &FlagFieldSubstOpts{UseFlags: flagset, Args: []string{"-name", "flagname"}},
+	}, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost", s.Host)
+	assert.Equal(t, "flagname", s.Inner.Name)
+}
+
+func TestFieldOrderContradictions(t *testing.T) {
+	for tag, msg := range map[string]string{
+		"order=file,yaml":          "unknown source yaml in order (expected one of file,dir,env,flag,default)",
+		"order=env,flag,env":       "source env is in the order more than once",
+		"order=default,env":        "default must be last in the order",
+		"order=flag,env,preferenv": "conf:preferenv contradicts the order, env must be first",
+		"envskip,order=env,file":   "conf:envskip contradicts the order, which has env",
+	} {
+		confops := processConfTagOptsValues(tag)
+		err := checkOrder(fieldOrder(confops), confops)
+		assert.EqualError(t, err, msg, tag)
+	}
+
+	s := struct {
+		Host string `conf:"order=env,env"`
+	}{}
+	err := NewProcessor(nil).Process(&ConfTagOpts{OrderOfOps: []int{DEFAULTTAGS}}, &s)
+	assert.EqualError(t, err, "field Host: source env is in the order more than once")
+}
+
+func TestProcessFieldOrderDir(t *testing.T) {
+	dir := writeConfigMap(t, map[string]string{"level": "dirlevel", "region": "dirregion"})
+	s := struct {
+		Level  string `yaml:"level" env:"ORDER_LEVEL" default:"info" conf:"order=env,default"`
+		Region string `yaml:"region" env:"ORDER_REGION" default:"us" conf:"order=dir,env"`
+	}{}
+	t.Setenv("ORDER_REGION", "envregion")
+	prov := Provenance{}
+	err := NewProcessor(nil).Process(&ConfTagOpts{
+		OrderOfOps: []int{DIRECTORY, DEFAULTTAGS, ENVTAGS},
+		DirOpts:    &DirFieldSubstOpts{Dir: dir},
+		Provenance: prov,
+	}, &s)
+	assert.Nil(t, err)
+	// the directory is not in the order, so the field gets its default
+	assert.Equal(t, "info", s.Level)
+	assert.Equal(t, STAGEDEFAULT, prov["Level"].Stage)
+	// the directory beats the env var
+	assert.Equal(t, "dirregion", s.Region)
+	assert.Equal(t, STAGEDIR, prov["Region"].Stage)
+}
+
+func TestProcessFieldOrderSameValue(t *testing.T) {
+	// the env var sets the value the field already had, which still counts as set by env
+	s := struct {
+		Port int `env:"ZZ_PORT" conf:"order=env"`
+	}{Port: 8080}
+	t.Setenv("ZZ_PORT", "8080")
+	err := NewProcessor(nil).Process(&ConfTagOpts{OrderOfOps: []int{ENVTAGS}}, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, 8080, s.Port)
+
+	// the env var is the same as the default, but still beats the flag
+	s2 := struct {
+		Port int `env:"ZZ_PORT" flag:"zzport2" default:"80" conf:"order=env,flag,default"`
+	}{}
+	t.Setenv("ZZ_PORT", "80")
+	prov := Provenance{}
+	err = NewProcessor(nil).Process(&ConfTagOpts{
+		FlagTagOpts: &FlagFieldSubstOpts{UseFlags: flag.NewFlagSet("test", flag.ContinueOnError), Args: []string{"-zzport2", "90"}},
+		Provenance:  prov,
+	}, &s2)
+	assert.Nil(t, err)
+	assert.Equal(t, 80, s2.Port)
+	assert.Equal(t, STAGEENV, prov["Port"].Stage)
+}
@@ -0,0 +1,160 @@
+package conftagz
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// PresentKeys is the set of keys which were present in a config file, as paths of
+// yaml (or json) key names like "sslstuff.cert" or "servers[1].ip". It lets the default
+// substituter tell a key set to a zero value (port: 0) apart from a key which is missing.
+type PresentKeys map[string]bool
+
+// Has returns true if the key path was present in the document
+func (pk PresentKeys) Has(key string) bool {
+	if pk == nil {
+		return false
+	}
+	// map values are in the document as keys, so "backends[primary].port" is "backends.primary.port"
+	key = mapIndexRE.ReplaceAllStringFunc(key, func(index string) string {
+		name := index[1 : len(index)-1]
+		if _, err := strconv.Atoi(name); err == nil {
+			return index
+		}
+		return "." + name
+	})
+	// json keys match case insensitively so are stored lower case
+	return pk[key] || pk[strings.ToLower(key)]
+}
+
+var mapIndexRE = regexp.MustCompile(`\[[^\]]+\]`)
+
+// FindPresentKeys returns every key path in the document. format is FORMATYAML
+// or FORMATJSON.
+func FindPresentKeys(data []byte, format string) (present PresentKeys, err error) {
+	present = make(PresentKeys)
+	switch format {
+	case FORMATYAML:
+		var doc yamlv3.Node
+		err = yamlv3.Unmarshal(data, &doc)
+		if err != nil {
+			return
+		}
+		walkYamlNode("", &doc, present)
+	case FORMATJSON:
+		if len(bytes.TrimSpace(data)) < 1 {
+			return
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		err = walkJSONTokens("", dec, present)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	default:
+		err = fmt.Errorf("config file format %s unsupported", format)
+	}
+	return
+}
+
+func walkYamlNode(path string, node *yamlv3.Node, present PresentKeys) {
+	switch node.Kind {
+	case yamlv3.DocumentNode:
+		for _, n := range node.Content {
+			walkYamlNode(path, n, present)
+		}
+	case yamlv3.AliasNode:
+		if node.Alias != nil {
+			walkYamlNode(path, node.Alias, present)
+		}
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if key == "<<" {
+				// merge key, the merged keys are at this level
+				merge := node.Content[i+1]
+				if merge.Kind == yamlv3.SequenceNode {
+					for _, m := range merge.Content {
+						walkYamlNode(path, m, present)
+					}
+				} else {
+					walkYamlNode(path, merge, present)
+				}
+				continue
+			}
+			keypath := addParentPath(path, key)
+			present[keypath] = true
+			walkYamlNode(keypath, node.Content[i+1], present)
+		}
+	case yamlv3.SequenceNode:
+		for n, item := range node.Content {
+			itempath := fmt.Sprintf("%s[%d]", path, n)
+			present[itempath] = true
+			walkYamlNode(itempath, item, present)
+		}
+	}
+}
+
+func walkJSONTokens(path string, dec *json.Decoder, present PresentKeys) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil
+	}
+	switch delim {
+	case '{':
+		for dec.More() {
+			tok, err = dec.Token()
+			if err != nil {
+				return err
+			}
+			key, ok := tok.(string)
+			if !ok {
+				return fmt.Errorf("json: expected a key, got %v", tok)
+			}
+			keypath := addParentPath(path, strings.ToLower(key))
+			present[keypath] = true
+			err = walkJSONTokens(keypath, dec, present)
+			if err != nil {
+				return err
+			}
+		}
+	case '[':
+		for n := 0; dec.More(); n++ {
+			itempath := fmt.Sprintf("%s[%d]", path, n)
+			present[itempath] = true
+			err = walkJSONTokens(itempath, dec, present)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// the closing delimiter
+	_, err = dec.Token()
+	return err
+}
+
+// isLeafType is true if the type is not a struct, a pointer to one,
+// or a slice of them - i.e. the default substituter can set it directly
+func (c converterMap) isLeafType(t reflect.Type) bool {
+	if c.isStructOrPtrToStruct(t) {
+		return false
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice && c.isStructOrPtrToStruct(t.Elem()) {
+		return false
+	}
+	return !c.isMapOfStructs(t)
+}
@@ -0,0 +1,140 @@
+package conftagz
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type PresenceServer struct {
+	IP   string `yaml:"ip" json:"ip" default:"127.0.0.1" conf:"zeroisvalid"`
+	Port int    `yaml:"port" json:"port" default:"80" conf:"zeroisvalid"`
+}
+
+type PresenceStruct struct {
+	Port    int              `yaml:"port" json:"port" default:"8080" conf:"zeroisvalid"`
+	Timeout *int             `yaml:"timeout" json:"timeout" default:"30" conf:"zeroisvalid"`
+	Retries int              `yaml:"retries" json:"retries" default:"3"`
+	Name    string           `yaml:"name" json:"name" default:"app" conf:"zeroisvalid"`
+	Servers []PresenceServer `yaml:"servers" json:"servers"`
+}
+
+func TestFindPresentKeys(t *testing.T) {
+	yml := `
+base: &base
+  ip: ""
+port: 0
+servers:
+  - <<: *base
+    port: 0
+  - ip: 10.0.0.1
+`
+	present, err := FindPresentKeys([]byte(yml), FORMATYAML)
+	assert.Nil(t, err)
+	for _, key := range []string{"port", "servers", "servers[0]", "servers[0].ip", "servers[0].port", "servers[1].ip"} {
+		assert.True(t, present.Has(key), key)
+	}
+	assert.False(t, present.Has("retries"))
+	assert.False(t, present.Has("servers[1].port"))
+
+	jsn := `{"Port": 0, "servers": [{"ip": ""}, {"port": 0}], "name": null}`
+	present, err = FindPresentKeys([]byte(jsn), FORMATJSON)
+	assert.Nil(t, err)
+	for _, key := range []string{"port", "name", "servers[0].ip", "servers[1].port"} {
+		assert.True(t, present.Has(key), key)
+	}
+	assert.False(t, present.Has("servers[0].port"))
+
+	_, err = FindPresentKeys([]byte(`{"port": `), FORMATJSON)
+	assert.NotNil(t, err)
+}
+
+func TestDefaultsKeepPresentZeroValues(t *testing.T) {
+	yml := `
+port: 0
+timeout: 0
+retries: 0
+servers:
+  - ip: ""
+    port: 0
+  - ip: 10.0.0.1
+`
+	present, err := FindPresentKeys([]byte(yml), FORMATYAML)
+	assert.Nil(t, err)
+
+	zero := 0
+	s := PresenceStruct{Timeout: &zero, Servers: []PresenceServer{{}, {IP: "10.0.0.1"}}}
+	_, err = SubsistuteDefaults(&s, &DefaultFieldSubstOpts{Present: present})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, s.Port)
+	assert.Equal(t, 0, *s.Timeout)
+	// not tagged zeroisvalid
+	assert.Equal(t, 3, s.Retries)
+	// not in the file
+	assert.Equal(t, "app", s.Name)
+	assert.Equal(t, "", s.Servers[0].IP)
+	assert.Equal(t, 0, s.Servers[0].Port)
+	assert.Equal(t, "10.0.0.1", s.Servers[1].IP)
+	assert.Equal(t, 80, s.Servers[1].Port)
+
+	// ZeroIsValid covers every field
+	s = PresenceStruct{}
+	_, err = SubsistuteDefaults(&s, &DefaultFieldSubstOpts{Present: present, ZeroIsValid: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, s.Retries)
+
+	// without the present keys the old behavior is kept
+	s = PresenceStruct{}
+	_, err = SubsistuteDefaults(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 8080, s.Port)
+	assert.Equal(t, 30, *s.Timeout)
+}
+
+func TestProcessConfigFileZeroIsValid(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfFile(t, dir, "conf.json", `{"port": 0, "retries": 0}`)
+
+	var s PresenceStruct
+	err := NewProcessor(nil).Process(&ConfTagOpts{
+		OrderOfOps: []int{CONFIGFILE, DEFAULTTAGS},
+		FileOpts:   &ConfigFileOpts{Paths: []string{path}},
+	}, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, s.Port)
+	assert.Equal(t, 3, s.Retries)
+	assert.Equal(t, "app", s.Name)
+}
+
+func TestProcessZeroIsValidEachFile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfFile(t, dir, "conf.yaml", "port: 0\n")
+	p := NewProcessor(&ConfTagOpts{
+		OrderOfOps: []int{CONFIGFILE, DEFAULTTAGS},
+		FileOpts:   &ConfigFileOpts{Paths: []string{path}},
+	})
+
+	var s PresenceStruct
+	err := p.Process(nil, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, s.Port)
+
+	// the port is no longer in the file, so the default is used
+	writeConfFile(t, dir, "conf.yaml", "retries: 1\n")
+	s = PresenceStruct{}
+	err = p.Process(nil, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, 8080, s.Port)
+
+	// and the keys of the last file are not kept once it is gone
+	fileopts := &ConfigFileOpts{Paths: []string{path}}
+	_, err = LoadConfigFile(&PresenceStruct{}, fileopts, []string{})
+	assert.Nil(t, err)
+	assert.True(t, fileopts.Present.Has("retries"))
+	assert.Nil(t, os.Remove(path))
+	_, err = LoadConfigFile(&PresenceStruct{}, fileopts, []string{})
+	assert.Nil(t, err)
+	assert.Nil(t, fileopts.Present)
+	assert.Equal(t, "", fileopts.Loaded)
+}
@@ -0,0 +1,57 @@
+package conftagz
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// Processor holds all the state conftagz needs while processing structs:
+// the registered test and default functions and converters, the registered cobra commands,
+// the cache of structs whose flags have already been set up, and the default
+// ConfTagOpts used when Process is called with nil options.
+//
+// Each Processor is independent of every other Processor, so two libraries in
+// the same binary (or two parallel tests) can each use their own without
+// stepping on each other. The package level functions (Process, RegisterTestFunc,
+// etc.) all operate on a shared default Processor.
+type Processor struct {
+	// Opts are the options used by Process if it is called with nil options
+	Opts *ConfTagOpts
+
+	testFuncs     map[string]TestFunc
+	defaultFuncs  map[string]DefaultFunc
+	converters    converterMap
+	cobraCommands map[string]*cobra.Command
+
+	preprocessedStructFlags      map[interface{}]*ProcessedFlagTags
+	preprocessedCobraStructFlags map[interface{}]*ProcessedCobraTags
+	usingCobraFlags              bool
+}
+
+// NewProcessor returns a new Processor with empty registries, apart from the built
+// in test functions like $(ip) and $(url) and the converter for url.URL. opts may
+// be nil, in which case Process uses the standard defaults.
+func NewProcessor(opts *ConfTagOpts) *Processor {
+	return &Processor{
+		Opts:                         opts,
+		testFuncs:                    builtinTestFuncs(),
+		defaultFuncs:                 make(map[string]DefaultFunc),
+		converters:                   builtinConverters(),
+		cobraCommands:                make(map[string]*cobra.Command),
+		preprocessedStructFlags:      make(map[interface{}]*ProcessedFlagTags),
+		preprocessedCobraStructFlags: make(map[interface{}]*ProcessedCobraTags),
+	}
+}
+
+var defaultProcessor = NewProcessor(nil)
+
+// DefaultProcessor returns the Processor used by the package level functions
+func DefaultProcessor() *Processor {
+	return defaultProcessor
+}
+
+// Reset returns the Processor to the state it was in when created. All registered
+// functions, cobra commands and preprocessed flags are forgotten.
+func (p *Processor) Reset() {
+	opts := p.Opts
+	*p = *NewProcessor(opts)
+}
@@ -0,0 +1,144 @@
+package conftagz
+
+import (
+	"flag"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type ProcessorStruct struct {
+	Field1 string `default:"$(pdefault)" test:"$(ptest)"`
+	Field2 int    `default:"5" flag:"field2"`
+}
+
+func TestProcessorsAreIndependent(t *testing.T) {
+	p1 := NewProcessor(nil)
+	p2 := NewProcessor(nil)
+
+	p1.RegisterDefaultFunc("pdefault", func(fieldname string) interface{} {
+		return "one"
+	})
+	p2.RegisterDefaultFunc("pdefault", func(fieldname string) interface{} {
+		return "two"
+	})
+	p1.RegisterTestFunc("ptest", func(val interface{}, fieldname string) bool {
+		return val.(string) == "one"
+	})
+	p2.RegisterTestFunc("ptest", func(val interface{}, fieldname string) bool {
+		return val.(string) == "two"
+	})
+
+	s1 := &ProcessorStruct{}
+	s2 := &ProcessorStruct{}
+
+	err := p1.Process(&ConfTagOpts{OrderOfOps: []int{DEFAULTTAGS, TESTTAGS}}, s1)
+	assert.Nil(t, err)
+	err = p2.Process(&ConfTagOpts{OrderOfOps: []int{DEFAULTTAGS, TESTTAGS}}, s2)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "one", s1.Field1)
+	assert.Equal(t, "two", s2.Field1)
+
+	// the test func of p1 should fail on the struct filled in by p2
+	_, err = p1.RunTestFlags(s2, nil)
+	assert.EqualError(t, err, "field Field1: value two !$(ptest)")
+}
+
+func TestProcessorDefaultOpts(t *testing.T) {
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	p := NewProcessor(&ConfTagOpts{
+		OrderOfOps: []int{DEFAULTTAGS, FLAGTAGS},
+		FlagTagOpts: &FlagFieldSubstOpts{
+			UseFlags: flagset,
+			Args:     []string{"-field2", "77"},
+		},
+	})
+	p.RegisterDefaultFunc("pdefault", func(fieldname string) interface{} {
+		return "dflt"
+	})
+
+	s := &ProcessorStruct{}
+	err := p.Process(nil, s)
+	assert.Nil(t, err)
+	assert.Equal(t, "dflt", s.Field1)
+	assert.Equal(t, 77, s.Field2)
+}
+
+func TestProcessorsParallel(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			p := NewProcessor(&ConfTagOpts{OrderOfOps: []int{DEFAULTTAGS, TESTTAGS}})
+			want := string(rune('a' + n))
+			p.RegisterDefaultFunc("pdefault", func(fieldname string) interface{} {
+				return want
+			})
+			p.RegisterTestFunc("ptest", func(val interface{}, fieldname string) bool {
+				return val.(string) == want
+			})
+			s := &ProcessorStruct{}
+			err := p.Process(nil, s)
+			assert.Nil(t, err)
+			assert.Equal(t, want, s.Field1)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestProcessorReset(t *testing.T) {
+	p := NewProcessor(nil)
+	p.RegisterDefaultFunc("pdefault", func(fieldname string) interface{} {
+		return "one"
+	})
+	p.Reset()
+	assert.Equal(t, 0, len(p.defaultFuncs))
+	assert.Equal(t, 0, len(p.preprocessedStructFlags))
+}
+
+func TestProcessorDefaultOptsNotChanged(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfFile(t, dir, "conf.yaml", "field2: 3\n")
+	p := NewProcessor(&ConfTagOpts{
+		OrderOfOps:    []int{CONFIGFILE, ENVTAGS, DEFAULTTAGS},
+		FileOpts:      &ConfigFileOpts{Paths: []string{path}},
+		EnvOpts:       &EnvFieldSubstOpts{},
+		DefaultOpts:   &DefaultFieldSubstOpts{},
+		CollectErrors: true,
+		Provenance:    Provenance{},
+	})
+	p.RegisterDefaultFunc("pdefault", func(fieldname string) interface{} {
+		return "dflt"
+	})
+	err := p.Process(nil, &ProcessorStruct{})
+	assert.Nil(t, err)
+	assert.Equal(t, &ConfigFileOpts{Paths: []string{path}}, p.Opts.FileOpts)
+	assert.Equal(t, &EnvFieldSubstOpts{}, p.Opts.EnvOpts)
+	assert.Equal(t, &DefaultFieldSubstOpts{}, p.Opts.DefaultOpts)
+}
+
+func TestProcessCallerOptsNotChanged(t *testing.T) {
+	opts := &ConfTagOpts{
+		OrderOfOps:    []int{ENVTAGS, DEFAULTTAGS, TESTTAGS},
+		EnvOpts:       &EnvFieldSubstOpts{},
+		DefaultOpts:   &DefaultFieldSubstOpts{},
+		TestOpts:      &TestFieldSubstOpts{},
+		CollectErrors: true,
+	}
+	p := NewProcessor(nil)
+	p.RegisterDefaultFunc("pdefault", func(fieldname string) interface{} {
+		return "dflt"
+	})
+	p.RegisterTestFunc("ptest", func(val interface{}, fieldname string) bool {
+		return val.(string) == "dflt"
+	})
+	err := p.Process(opts, &ProcessorStruct{})
+	assert.Nil(t, err)
+	assert.False(t, opts.EnvOpts.CollectErrors)
+	assert.False(t, opts.DefaultOpts.CollectErrors)
+	assert.False(t, opts.TestOpts.CollectErrors)
+	assert.Nil(t, opts.FlagTagOpts)
+}
@@ -0,0 +1,233 @@
+package conftagz
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// STAGEINPUT is the source of any value which was already in the struct
+// when it was given to Process, i.e. it came from the yaml file
+const STAGEINPUT = "input"
+
+// ValueSource records where a value of a field came from
+type ValueSource struct {
+	// Stage is the stage which set the value: STAGEINPUT, STAGEFILE, STAGEDIR, STAGEENV, STAGEDEFAULT or STAGEFLAG
+	Stage string
+	// Key is the config file path, the env var name, the flag name, the default func name
+	// or the default value depending on the Stage. Empty for STAGEINPUT.
+	Key string
+	// Value is the value as set by the stage
+	Value interface{}
+}
+
+// FieldProvenance is the final source of a field's value, along with the
+// sources it overrode
+type FieldProvenance struct {
+	ValueSource
+	// Overrode are the earlier values of the field which were replaced, oldest first
+	Overrode []ValueSource
+}
+
+// Provenance maps the path of every field (as in "SSL.Cert" or "Servers[1].IP") to
+// where its value came from. Pass one in ConfTagOpts.Provenance to have Process fill it:
+//
+//	prov := conftagz.Provenance{}
+//	err := conftagz.Process(&conftagz.ConfTagOpts{Provenance: prov}, &config)
+//	fmt.Printf("Port came from %s\n", prov["Port"].Stage)
+type Provenance map[string]*FieldProvenance
+
+// leafField is a field which is not a struct, found by walking the struct
+type leafField struct {
+	value reflect.Value
+	// the field itself, then each of its parents
+	fields []reflect.StructField
+}
+
+// tag returns the first non-empty tag named tagname starting at the field
+// and looking up through its parents
+func (l *leafField) tag(tagname string) string {
+	for _, f := range l.fields {
+		if v := f.Tag.Get(tagname); len(v) > 0 {
+			return v
+		}
+	}
+	return ""
+}
+
+// walkLeafFields calls found for every non-struct exported field in the struct,
+// following pointers, and slices and maps of structs. Nil pointers are not followed.
+func (c converterMap) walkLeafFields(somestruct interface{}, found func(path string, leaf *leafField)) {
+	var walk func(parentpath string, parents []reflect.StructField, v reflect.Value)
+	walk = func(parentpath string, parents []reflect.StructField, v reflect.Value) {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if skipField(processConfTagOptsValues(field.Tag.Get(CONFFIELD))) {
+				continue
+			}
+			fields := append([]reflect.StructField{field}, parents...)
+			path := addParentPath(parentpath, field.Name)
+			fv := v.Field(i)
+			ft := field.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			switch {
+			case c.isStructType(ft):
+				walk(path, fields, fv)
+			case ft.Kind() == reflect.Slice && c.isStructOrPtrToStruct(ft.Elem()):
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						continue
+					}
+					fv = fv.Elem()
+				}
+				for n := 0; n < fv.Len(); n++ {
+					walk(fmt.Sprintf("%s[%d]", path, n), fields, fv.Index(n))
+				}
+			case c.isMapOfStructs(ft):
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						continue
+					}
+					fv = fv.Elem()
+				}
+				for _, key := range sortedMapKeys(fv) {
+					walk(mapPath(path, key.String()), fields, fv.MapIndex(key))
+				}
+			case ft.Kind() == reflect.Interface || ft.Kind() == reflect.Uintptr:
+				// unsupported
+			default:
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						continue
+					}
+					fv = fv.Elem()
+				}
+				found(path, &leafField{value: fv, fields: fields})
+			}
+		}
+	}
+	walk("", nil, reflect.ValueOf(somestruct))
+}
+
+func (c converterMap) isStructOrPtrToStruct(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return c.isStructType(t)
+}
+
+// snapshotValue copies the value of a leaf so later changes to the field
+// do not change it
+func snapshotValue(v reflect.Value) interface{} {
+	if !v.CanInterface() {
+		return nil
+	}
+	if v.Kind() == reflect.Slice && !v.IsNil() {
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+		return c.Interface()
+	}
+	if v.Kind() == reflect.Map && !v.IsNil() {
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), iter.Value())
+		}
+		return c.Interface()
+	}
+	return v.Interface()
+}
+
+// provenanceTracker compares the struct after each stage to what it was before
+// to find which fields each stage set
+type provenanceTracker struct {
+	conv       converterMap
+	prov       Provenance
+	somestruct interface{}
+	// the config file loaded by the CONFIGFILE stage
+	file string
+	// by stage, the Key of each field the stage set, by path, for stages which can
+	// use more than one name for a field, i.e. an env: tag with several names
+	keys map[string]map[string]string
+}
+
+func newProvenanceTracker(conv converterMap, prov Provenance, somestruct interface{}) *provenanceTracker {
+	t := &provenanceTracker{conv: conv, prov: prov, somestruct: somestruct, keys: make(map[string]map[string]string)}
+	conv.walkLeafFields(somestruct, func(path string, leaf *leafField) {
+		prov[path] = &FieldProvenance{ValueSource: ValueSource{Stage: STAGEINPUT, Value: snapshotValue(leaf.value)}}
+	})
+	return t
+}
+
+// stageKey returns the Key for a field set by the stage
+func stageKey(stage string, leaf *leafField) string {
+	switch stage {
+	case STAGEENV:
+		return leaf.tag(ENVFIELD)
+	case STAGEDEFAULT:
+		dflt := leaf.tag("default")
+		matches := matchDefaultFuncRE.FindStringSubmatch(dflt)
+		if len(matches) > 1 {
+			return matches[1]
+		}
+		return dflt
+	case STAGEFLAG:
+		if f := leaf.tag(FLAGFIELD); len(f) > 0 {
+			return f
+		}
+		return strings.Split(leaf.tag(COBRAFIELD), ",")[0]
+	}
+	return ""
+}
+
+// update records the fields set by the stage. touched are the paths the stage
+// reported as set, which is needed if the stage set a field to the value it already had.
+func (t *provenanceTracker) update(stage string, touched []string) {
+	touchedmap := make(map[string]bool)
+	for _, path := range touched {
+		touchedmap[path] = true
+	}
+	t.conv.walkLeafFields(t.somestruct, func(path string, leaf *leafField) {
+		val := snapshotValue(leaf.value)
+		p, ok := t.prov[path]
+		if !ok {
+			// the field is new, i.e. a nil pointer was filled in by the stage
+			p = &FieldProvenance{ValueSource: ValueSource{Stage: STAGEINPUT, Value: val}}
+			t.prov[path] = p
+			if !touchedmap[path] && leaf.value.IsZero() {
+				return
+			}
+		} else {
+			if !touchedmap[path] && reflect.DeepEqual(p.Value, val) {
+				return
+			}
+			// a zero value from the input was not really overridden
+			if p.Stage != STAGEINPUT || (p.Value != nil && !reflect.ValueOf(p.Value).IsZero()) {
+				p.Overrode = append(p.Overrode, p.ValueSource)
+			}
+		}
+		key := stageKey(stage, leaf)
+		if stage == STAGEFILE {
+			key = t.file
+		}
+		if name, ok := t.keys[stage][path]; ok {
+			key = name
+		}
+		p.ValueSource = ValueSource{Stage: stage, Key: key, Value: val}
+	})
+}
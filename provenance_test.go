@@ -0,0 +1,114 @@
+package conftagz
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+type ProvInner struct {
+	Cert string `yaml:"cert" env:"PROV_CERT"`
+}
+
+type ProvStruct struct {
+	Name    string     `yaml:"name"`
+	Port    int        `yaml:"port" env:"PROV_PORT" default:"8080" flag:"port"`
+	Host    string     `yaml:"host" default:"$(provhost)"`
+	Debug   bool       `yaml:"debug" env:"PROV_DEBUG"`
+	Tags    []string   `yaml:"tags" default:"a,b"`
+	SSL     *ProvInner `yaml:"ssl"`
+	Unset   string     `yaml:"unset"`
+	private int
+}
+
+func TestProvenance(t *testing.T) {
+	t.Setenv("PROV_PORT", "9090")
+	t.Setenv("PROV_CERT", "cert.pem")
+	t.Setenv("PROV_DEBUG", "true")
+
+	p := NewProcessor(nil)
+	p.RegisterDefaultFunc("provhost", func(fieldname string) interface{} {
+		return "localhost"
+	})
+
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	mystruct := &ProvStruct{Name: "fromyaml"}
+	prov := Provenance{}
+
+	err := p.Process(&ConfTagOpts{
+		Provenance: prov,
+		FlagTagOpts: &FlagFieldSubstOpts{
+			UseFlags: flagset,
+			Args:     []string{"-port", "7070"},
+		},
+	}, mystruct)
+	assert.Nil(t, err)
+
+	assert.Equal(t, STAGEINPUT, prov["Name"].Stage)
+	assert.Equal(t, "fromyaml", prov["Name"].Value)
+	assert.Equal(t, 0, len(prov["Name"].Overrode))
+
+	// default, then env, then flag
+	assert.Equal(t, STAGEFLAG, prov["Port"].Stage)
+	assert.Equal(t, "port", prov["Port"].Key)
+	assert.Equal(t, 7070, prov["Port"].Value)
+	assert.Equal(t, []ValueSource{
+		{Stage: STAGEDEFAULT, Key: "8080", Value: 8080},
+		{Stage: STAGEENV, Key: "PROV_PORT", Value: 9090},
+	}, prov["Port"].Overrode)
+
+	assert.Equal(t, STAGEDEFAULT, prov["Host"].Stage)
+	assert.Equal(t, "provhost", prov["Host"].Key)
+
+	assert.Equal(t, STAGEENV, prov["Debug"].Stage)
+	assert.Equal(t, true, prov["Debug"].Value)
+
+	assert.Equal(t, STAGEDEFAULT, prov["Tags"].Stage)
+	assert.Equal(t, []string{"a", "b"}, prov["Tags"].Value)
+
+	// struct pointer created by the default stage, filled in by env
+	assert.Equal(t, STAGEENV, prov["SSL.Cert"].Stage)
+	assert.Equal(t, "PROV_CERT", prov["SSL.Cert"].Key)
+	assert.Equal(t, 0, len(prov["SSL.Cert"].Overrode))
+
+	assert.Equal(t, STAGEINPUT, prov["Unset"].Stage)
+	assert.Equal(t, "", prov["Unset"].Value)
+
+	_, ok := prov["private"]
+	assert.False(t, ok)
+}
+
+func TestProvenanceFlagSameAsDefault(t *testing.T) {
+	s := struct {
+		Port int `flag:"zzp" default:"80"`
+	}{}
+	prov := Provenance{}
+	err := NewProcessor(nil).Process(&ConfTagOpts{
+		Provenance:  prov,
+		FlagTagOpts: &FlagFieldSubstOpts{UseFlags: flag.NewFlagSet("test", flag.ContinueOnError), Args: []string{"-zzp", "80"}},
+	}, &s)
+	assert.Nil(t, err)
+	assert.Equal(t, 80, s.Port)
+	assert.Equal(t, STAGEFLAG, prov["Port"].Stage)
+	assert.Equal(t, "zzp", prov["Port"].Key)
+	assert.Equal(t, []ValueSource{{Stage: STAGEDEFAULT, Key: "80", Value: 80}}, prov["Port"].Overrode)
+
+	// and the same for cobra flags
+	c := struct {
+		Port int `cflag:"zzp" cobra:"root" default:"80"`
+	}{}
+	p := NewProcessor(nil)
+	cmd := &cobra.Command{Use: "app"}
+	p.RegisterCobraCmd("root", cmd)
+	err = p.PreProcessCobraFlags(&c, nil)
+	assert.Nil(t, err)
+	err = cmd.ParseFlags([]string{"--zzp", "80"})
+	assert.Nil(t, err)
+	prov = Provenance{}
+	err = p.Process(&ConfTagOpts{Provenance: prov}, &c)
+	assert.Nil(t, err)
+	assert.Equal(t, 80, c.Port)
+	assert.Equal(t, STAGEFLAG, prov["Port"].Stage)
+}
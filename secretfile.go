@@ -0,0 +1,84 @@
+package conftagz
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// FILEFIELD is the tag naming a file holding the value of the field, i.e. a
+// mounted secret like file:"/run/secrets/db_password"
+const FILEFIELD = "file"
+
+// FILEENVSUFFIX is added to an env var name to get the env var holding the path
+// of a file with the value, i.e. SSL_KEY_FILE for SSL_KEY. See EnvFieldSubstOpts.FileEnv
+const FILEENVSUFFIX = "_FILE"
+
+// DEFAULTMAXFILESIZE is the largest file read for the value of a field, unless MaxFileSize is set
+const DEFAULTMAXFILESIZE = 64 * 1024
+
+// readSecretFile reads the value of a field from a file. Trailing newlines are
+// removed. Files anyone can read are refused unless opts.AllowWorldReadable is set.
+func readSecretFile(path string, opts *EnvFieldSubstOpts) (string, error) {
+	return readValueFile(path, opts.MaxFileSize, !opts.AllowWorldReadable)
+}
+
+// readValueFile reads a file holding the value of one field, with trailing newlines
+// removed. If secret is true files anyone can read are refused.
+func readValueFile(path string, max int64, secret bool) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("file %s is not a regular file", path)
+	}
+	// windows has no such permissions
+	if secret && runtime.GOOS != "windows" && info.Mode().Perm()&0o004 != 0 {
+		return "", fmt.Errorf("secret file %s is readable by everyone (mode %s)", path, info.Mode().Perm())
+	}
+	if max <= 0 {
+		max = DEFAULTMAXFILESIZE
+	}
+	data, err := io.ReadAll(io.LimitReader(f, max+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > max {
+		return "", fmt.Errorf("file %s is larger than %d bytes", path, max)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// lookupSecretFile reads the value of a field whose env var is not set from a file. The
+// file is named by an env var with FILEENVSUFFIX, if opts.FileEnv is set, or else by the
+// file: tag. name is the env var, or the path from the file: tag. A file: tag naming a
+// file which does not exist is not an error, just as with a missing env var.
+func lookupSecretFile(opts *EnvFieldSubstOpts, aliases []envAlias, filetag string, m map[string]string) (name string, val string, found bool, err error) {
+	if opts.FileEnv {
+		for _, a := range aliases {
+			name = a.name + FILEENVSUFFIX
+			if path, ok := m[name]; ok {
+				val, err = readSecretFile(path, opts)
+				return name, val, err == nil, err
+			}
+		}
+	}
+	if len(filetag) < 1 {
+		return "", "", false, nil
+	}
+	if _, err = os.Stat(filetag); errors.Is(err, fs.ErrNotExist) {
+		return "", "", false, nil
+	}
+	val, err = readSecretFile(filetag, opts)
+	return filetag, val, err == nil, err
+}
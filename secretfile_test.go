@@ -0,0 +1,105 @@
+package conftagz
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeSecret(t *testing.T, name string, content string, mode os.FileMode) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(content), mode))
+	// WriteFile is subject to the umask
+	assert.Nil(t, os.Chmod(path, mode))
+	return path
+}
+
+type SecretStruct struct {
+	SSLKey     string `env:"SSL_KEY"`
+	DBPassword string
+	Port       int `env:"APP_PORT"`
+}
+
+func TestFileEnv(t *testing.T) {
+	keyfile := writeSecret(t, "ssl_key", "-----KEY-----\n", 0o600)
+	portfile := writeSecret(t, "port", "8080\r\n", 0o400)
+	m := map[string]string{"SSL_KEY_FILE": keyfile, "APP_PORT_FILE": portfile}
+	used := map[string]string{}
+
+	var s SecretStruct
+	_, err := EnvFieldSubstitutionFromMap(&s, &EnvFieldSubstOpts{FileEnv: true, UsedNames: used}, m)
+	assert.Nil(t, err)
+	assert.Equal(t, "-----KEY-----", s.SSLKey)
+	assert.Equal(t, 8080, s.Port)
+	assert.Equal(t, "SSL_KEY_FILE", used["SSLKey"])
+	assert.Equal(t, map[string]string{"SSL_KEY_FILE": keyfile, "APP_PORT_FILE": portfile}, m)
+
+	// the env var itself wins
+	s = SecretStruct{}
+	m["SSL_KEY"] = "from env"
+	_, err = EnvFieldSubstitutionFromMap(&s, &EnvFieldSubstOpts{FileEnv: true}, m)
+	assert.Nil(t, err)
+	assert.Equal(t, "from env", s.SSLKey)
+
+	// only if asked for
+	s = SecretStruct{}
+	_, err = EnvFieldSubstitutionFromMap(&s, nil, map[string]string{"SSL_KEY_FILE": keyfile})
+	assert.Nil(t, err)
+	assert.Equal(t, "", s.SSLKey)
+}
+
+type FileTagStruct struct {
+	DBPassword string `env:"DB_PASSWORD" file:"db_password"`
+	APIKey     string `file:"api_key"`
+	Missing    string `file:"missing"`
+}
+
+// inTempDir runs the test in a new directory, so file: tags can use relative paths
+func inTempDir(t *testing.T) {
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+}
+
+func TestFileTag(t *testing.T) {
+	inTempDir(t)
+	assert.Nil(t, os.WriteFile("db_password", []byte("hunter2\n\n"), 0o600))
+	assert.Nil(t, os.Chmod("db_password", 0o640))
+
+	var s FileTagStruct
+	touched, err := EnvFieldSubstitutionFromMap(&s, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"DBPassword"}, touched)
+	assert.Equal(t, "hunter2", s.DBPassword)
+	assert.Equal(t, "", s.Missing)
+
+	s = FileTagStruct{}
+	_, err = EnvFieldSubstitutionFromMap(&s, nil, map[string]string{"DB_PASSWORD": "env"})
+	assert.Nil(t, err)
+	assert.Equal(t, "env", s.DBPassword)
+}
+
+func TestSecretFileChecks(t *testing.T) {
+	inTempDir(t)
+	assert.Nil(t, os.WriteFile("api_key", []byte("key"), 0o600))
+	assert.Nil(t, os.Chmod("api_key", 0o644))
+
+	var s FileTagStruct
+	_, err := EnvFieldSubstitutionFromMap(&s, nil, nil)
+	assert.EqualError(t, err, "field APIKey: secret file api_key is readable by everyone (mode -rw-r--r--)")
+	_, err = EnvFieldSubstitutionFromMap(&s, &EnvFieldSubstOpts{AllowWorldReadable: true}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "key", s.APIKey)
+
+	big := writeSecret(t, "big", strings.Repeat("x", 100), 0o600)
+	s = FileTagStruct{}
+	_, err = EnvFieldSubstitutionFromMap(&s, &EnvFieldSubstOpts{FileEnv: true, MaxFileSize: 99, AllowWorldReadable: true}, map[string]string{"DB_PASSWORD_FILE": big})
+	assert.EqualError(t, err, "field DBPassword: file "+big+" is larger than 99 bytes")
+
+	_, err = EnvFieldSubstitutionFromMap(&s, &EnvFieldSubstOpts{FileEnv: true}, map[string]string{"DB_PASSWORD_FILE": "nope"})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,136 @@
+package conftagz
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// DEFAULTSEP separates the items of a list in a default:, env: or flag: tag value,
+// unless the field has a conf:"sep=;" tag
+const DEFAULTSEP = ","
+
+// isScalarType is true for the types which can be parsed from a single string,
+// i.e. the element types of slices conftagz can fill from a list
+func (c converterMap) isScalarType(t reflect.Type) bool {
+	if c.isConvertedType(t) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// isScalarSliceType is true for a slice of scalars, i.e. []string or []time.Duration
+func (c converterMap) isScalarSliceType(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && !c.isConvertedType(t) && c.isScalarType(t.Elem())
+}
+
+// splitList splits s on sep. An empty s is an empty list.
+func splitList(s string, sep string) []string {
+	if len(s) < 1 {
+		return nil
+	}
+	if len(sep) < 1 {
+		sep = DEFAULTSEP
+	}
+	return strings.Split(s, sep)
+}
+
+// appendSliceElems parses each of vals as the element type of the slice and
+// appends them. Space around anything other than a string is ignored.
+func (c converterMap) appendSliceElems(sliceValue reflect.Value, vals []string, layout string) error {
+	elemType := sliceValue.Type().Elem()
+	for _, val := range vals {
+		if elemType.Kind() != reflect.String || c.isConvertedType(elemType) {
+			val = strings.TrimSpace(val)
+		}
+		v, err := c.convertScalar(elemType, val, layout)
+		if err != nil {
+			return fmt.Errorf("%s %s", val, err.Error())
+		}
+		sliceValue.Set(reflect.Append(sliceValue, v))
+	}
+	return nil
+}
+
+// sliceFlagValue is a flag.Value (and a pflag.Value) for a slice field. The flag can be
+// repeated, i.e. --peer a --peer b, and each use can also hold a list, i.e. --peer a,b
+type sliceFlagValue struct {
+	conv   converterMap
+	t      reflect.Type
+	layout string
+	sep    string
+	val    reflect.Value
+	set    bool
+	// the values are added to the field rather than replacing it (conf:"append")
+	append bool
+}
+
+func (v *sliceFlagValue) String() string {
+	if v == nil || !v.set {
+		return ""
+	}
+	var items []string
+	for n := 0; n < v.val.Len(); n++ {
+		items = append(items, fmt.Sprint(v.val.Index(n).Interface()))
+	}
+	sep := v.sep
+	if len(sep) < 1 {
+		sep = DEFAULTSEP
+	}
+	return strings.Join(items, sep)
+}
+
+func (v *sliceFlagValue) Set(s string) error {
+	if !v.set {
+		v.val = reflect.New(v.t).Elem()
+		v.set = true
+	}
+	return v.conv.appendSliceElems(v.val, splitList(s, v.sep), v.layout)
+}
+
+func (v *sliceFlagValue) Type() string {
+	return "list"
+}
+
+// apply sets the field to the values of the flag, or adds them to it. It
+// returns false if the flag was not used.
+func (v *sliceFlagValue) apply(fieldValue reflect.Value) bool {
+	if !v.set {
+		return false
+	}
+	if !v.append {
+		fieldValue.Set(v.val)
+		return true
+	}
+	fieldValue.Set(reflect.AppendSlice(fieldValue, v.val))
+	return true
+}
+
+// listFlagValue is the flag for a slice or map field. It is both a flag.Value and
+// a pflag.Value.
+type listFlagValue interface {
+	flag.Value
+	Type() string
+	// apply sets the field from the flag. It returns false if the flag was not used.
+	apply(fieldValue reflect.Value) bool
+}
+
+// newListFlagValue returns the flag for a slice or map field, or nil if the
+// field is not a slice or map which can be set from a flag
+func (c converterMap) newListFlagValue(t reflect.Type, layout string, confops map[string]string) listFlagValue {
+	switch {
+	case c.isScalarSliceType(t):
+		return &sliceFlagValue{conv: c, t: t, layout: layout, sep: listSep(confops), append: appendList(confops)}
+	case c.isMapType(t) && !c.isMapOfStructs(t):
+		return &mapFlagValue{conv: c, t: t, layout: layout, sep: listSep(confops), append: appendList(confops)}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package conftagz
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+type SliceListStruct struct {
+	Peers    []string        `env:"LIST_PEERS" flag:"peer" default:"localhost"`
+	Ports    []int           `env:"LIST_PORTS" flag:"port" conf:"sep=;"`
+	Weights  []float64       `env:"LIST_WEIGHTS" default:"0.5,1.5"`
+	Backoffs []time.Duration `env:"LIST_BACKOFFS" flag:"backoff"`
+	Extra    []string        `env:"LIST_EXTRA" flag:"extra" conf:"append"`
+	Labels   map[string]int  `env:"LIST_LABELS" conf:"sep=;,append"`
+}
+
+func TestSliceDefaults(t *testing.T) {
+	var s SliceListStruct
+	_, err := SubsistuteDefaults(&s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"localhost"}, s.Peers)
+	assert.Equal(t, []float64{0.5, 1.5}, s.Weights)
+	assert.Nil(t, s.Ports)
+
+	_, err = SubsistuteDefaults(&struct {
+		S []int `default:"1;2;x" conf:"sep=;"`
+	}{}, nil)
+	assert.EqualError(t, err, "field S: default value 1;2;x x not a number")
+}
+
+func TestSliceEnv(t *testing.T) {
+	s := SliceListStruct{
+		Peers:  []string{"file1"},
+		Extra:  []string{"file"},
+		Labels: map[string]int{"a": 1},
+	}
+	m := map[string]string{
+		"LIST_PEERS":    "a,b",
+		"LIST_PORTS":    "80; 443",
+		"LIST_BACKOFFS": "1s,1m",
+		"LIST_EXTRA":    "env1,env2",
+		"LIST_LABELS":   "b=2;c=3",
+	}
+	touched, err := EnvFieldSubstitutionFromMap(&s, nil, m)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"Peers", "Ports", "Backoffs", "Extra", "Labels"}, touched)
+	assert.Equal(t, []string{"a", "b"}, s.Peers)
+	assert.Equal(t, []int{80, 443}, s.Ports)
+	assert.Equal(t, []time.Duration{time.Second, time.Minute}, s.Backoffs)
+	assert.Equal(t, []string{"file", "env1", "env2"}, s.Extra)
+	assert.Equal(t, map[string]int{"a": 1, "b": 2, "c": 3}, s.Labels)
+
+	_, err = EnvFieldSubstitutionFromMap(&s, nil, map[string]string{"LIST_PORTS": "80,443"})
+	assert.EqualError(t, err, "field Ports: map (env) LIST_PORTS value 80,443 80,443 not a number")
+
+	_, err = EnvFieldSubstitutionFromMap(&struct {
+		S []*InnerStruct `env:"LIST_STRUCTS"`
+	}{}, nil, map[string]string{"LIST_STRUCTS": "a"})
+	assert.NotNil(t, err)
+}
+
+func TestSliceFlags(t *testing.T) {
+	s := SliceListStruct{Peers: []string{"file1"}, Extra: []string{"file"}}
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	p := NewProcessor(nil)
+	err := p.ProcessFlags(&s, &FlagFieldSubstOpts{
+		UseFlags: flagset,
+		Args:     []string{"-peer", "a", "-peer", "b,c", "-port", "80;443", "-backoff", "2s", "-extra", "x"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, s.Peers)
+	assert.Equal(t, []int{80, 443}, s.Ports)
+	assert.Equal(t, []time.Duration{2 * time.Second}, s.Backoffs)
+	assert.Equal(t, []string{"file", "x"}, s.Extra)
+
+	// finalizing again does not append twice
+	err = p.ProcessFlags(&s, &FlagFieldSubstOpts{UseFlags: flagset})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"file", "x"}, s.Extra)
+
+	var s2 SliceListStruct
+	flagset = flag.NewFlagSet("test", flag.ContinueOnError)
+	err = NewProcessor(nil).ProcessFlags(&s2, &FlagFieldSubstOpts{
+		UseFlags: flagset,
+		Args:     []string{"-port", "eighty"},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestSliceCobra(t *testing.T) {
+	s := struct {
+		Peers []string `cflag:"peer,p" cobra:"root"`
+		Ports []uint16 `cflag:"port" cobra:"root" conf:"append"`
+	}{Ports: []uint16{22}}
+	rootCmd := &cobra.Command{Use: "app"}
+	p := NewProcessor(nil)
+	p.RegisterCobraCmd("root", rootCmd)
+	err := p.PreProcessCobraFlags(&s, nil)
+	assert.Nil(t, err)
+	err = rootCmd.ParseFlags([]string{"-p", "a", "--peer", "b", "--port", "80,443"})
+	assert.Nil(t, err)
+	err = p.PostProcessCobraFlags()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, s.Peers)
+	assert.Equal(t, []uint16{22, 80, 443}, s.Ports)
+
+	err = rootCmd.ParseFlags([]string{"--port", "70000"})
+	assert.NotNil(t, err)
+}
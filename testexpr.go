@@ -0,0 +1,335 @@
+package conftagz
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	testLEAF = iota
+	testAND
+	testOR
+	testNOT
+	testEACH   // each(...), every item of a slice or value of a map
+	testKEYS   // keys(...), every key of a map
+	testVALUES // values(...), every value of a map
+)
+
+// testItemFuncs are the tests of the items of a slice or map
+var testItemFuncs = map[string]int{"each": testEACH, "keys": testKEYS, "values": testVALUES}
+
+// testExpr is a node of a parsed test: tag. A leaf runs ops[leaf] of its testConfOp,
+// the others combine the results of args.
+type testExpr struct {
+	kind int
+	leaf int
+	args []*testExpr
+	// as written in the tag, for the error of a failed !
+	src string
+}
+
+// testExprParser compiles a test: tag like ">=1024 || =0" or "!(~^tmp) && !=none".
+//
+//	or      := and { "||" and }
+//	and     := unary { ("," | "&&") unary }
+//	unary   := "!" unary | "(" or ")" | items "(" or ")" | test
+//	items   := "each" | "keys" | "values"
+//	test    := "$(" name ")" | required_with=.Field | required | notblank |
+//	           oneof=a|b | unique | unique=Field | sorted | sortedby=Field |
+//	           op operand | len op operand
+//	op      := "==" | "=" | "!=" | "~" | "!~" | "<" | ">" | "<=" | ">="
+//
+// An operand runs to the next ",", "||", "&&" or ")" outside of brackets, or can be
+// put in single quotes to have any of those in it.
+type testExprParser struct {
+	s         string
+	pos       int
+	testFuncs map[string]TestFunc
+	ret       *testConfOp
+}
+
+// testOperators are the operators of a test, longest first
+var testOperators = []struct {
+	s  string
+	op int
+}{
+	{"==", EQ}, {"!=", NE}, {"!~", NOTREGEX}, {"<=", LTE}, {">=", GTE},
+	{"=", EQ}, {"<", LT}, {">", GT}, {"~", REGEX},
+}
+
+func (p *testExprParser) at(prefix string) bool {
+	return strings.HasPrefix(p.s[p.pos:], prefix)
+}
+
+// atWord is true if the next thing is the word w, i.e. required but not required_with
+func (p *testExprParser) atWord(w string) bool {
+	if !p.at(w) {
+		return false
+	}
+	return p.pos+len(w) >= len(p.s) || !isDotEnvNameChar(p.s[p.pos+len(w)], false)
+}
+
+func (p *testExprParser) skipSpaces() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *testExprParser) parse() (*testExpr, error) {
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.s) {
+		return nil, fmt.Errorf("test: unexpected %q", p.s[p.pos:])
+	}
+	return e, nil
+}
+
+func (p *testExprParser) or() (*testExpr, error) {
+	e, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	var or *testExpr
+	for p.skipSpaces(); p.at("||"); p.skipSpaces() {
+		p.pos += 2
+		next, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		if or == nil {
+			or = &testExpr{kind: testOR, args: []*testExpr{e}}
+		}
+		or.args = append(or.args, next)
+	}
+	if or != nil {
+		return or, nil
+	}
+	return e, nil
+}
+
+func (p *testExprParser) and() (*testExpr, error) {
+	e, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	var and *testExpr
+	for p.skipSpaces(); p.at(",") || p.at("&&"); p.skipSpaces() {
+		if p.at(",") {
+			p.pos++
+		} else {
+			p.pos += 2
+		}
+		next, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if and == nil {
+			and = &testExpr{kind: testAND, args: []*testExpr{e}}
+		}
+		and.args = append(and.args, next)
+	}
+	if and != nil {
+		return and, nil
+	}
+	return e, nil
+}
+
+func (p *testExprParser) unary() (*testExpr, error) {
+	p.skipSpaces()
+	start := p.pos
+	switch {
+	case p.at("!") && !p.at("!=") && !p.at("!~"):
+		p.pos++
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &testExpr{kind: testNOT, args: []*testExpr{e}, src: strings.TrimSpace(p.s[start:p.pos])}, nil
+	case p.at("("):
+		return p.group()
+	}
+	for name, kind := range testItemFuncs {
+		if p.at(name + "(") {
+			p.pos += len(name)
+			e, err := p.group()
+			if err != nil {
+				return nil, err
+			}
+			return &testExpr{kind: kind, args: []*testExpr{e}, src: p.s[start:p.pos]}, nil
+		}
+	}
+	return p.test()
+}
+
+// group reads an or in parentheses
+func (p *testExprParser) group() (*testExpr, error) {
+	p.pos++
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if !p.at(")") {
+		return nil, fmt.Errorf("test: missing ) in %s", p.s)
+	}
+	p.pos++
+	return e, nil
+}
+
+// operand reads the operand of a test. quoted is true if it was in single quotes.
+func (p *testExprParser) operand() (s string, quoted bool, err error) {
+	p.skipSpaces()
+	if p.at("'") {
+		end := strings.IndexByte(p.s[p.pos+1:], '\'')
+		if end < 0 {
+			return "", true, fmt.Errorf("test: unterminated quote in %s", p.s)
+		}
+		s = p.s[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return s, true, nil
+	}
+	start := p.pos
+	depth := 0
+	for ; p.pos < len(p.s); p.pos++ {
+		c := p.s[p.pos]
+		switch {
+		case c == '\\':
+			// a regex escape, i.e. \( or \,
+			p.pos++
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case (c == ')' || c == ']' || c == '}') && depth > 0:
+			depth--
+		case depth == 0 && (c == ',' || c == ')' || p.at("||") || p.at("&&")):
+			return strings.TrimSpace(p.s[start:p.pos]), false, nil
+		}
+	}
+	if p.pos > len(p.s) {
+		p.pos = len(p.s)
+	}
+	return strings.TrimSpace(p.s[start:]), false, nil
+}
+
+// test reads a single test, adding it to the ops of p.ret
+func (p *testExprParser) test() (*testExpr, error) {
+	p.skipSpaces()
+	var op *testOp
+	switch {
+	case p.at("$("):
+		end := strings.IndexByte(p.s[p.pos:], ')')
+		if end < 0 {
+			return nil, fmt.Errorf("test: missing ) in %s", p.s)
+		}
+		name := p.s[p.pos+2 : p.pos+end]
+		f := lookupTestFunc(p.testFuncs, name)
+		if f == nil {
+			return nil, fmt.Errorf("test: no test function %s", name)
+		}
+		debugf("test: Found a test func: %s\n", name)
+		p.pos += end + 1
+		op = &testOp{Operator: TESTFUNC, testFunc: f, testFuncName: name}
+	case p.at("required_with=") || p.at("required_without="):
+		n := strings.IndexByte(p.s[p.pos:], '=')
+		rule, _, _ := parseRequiredRule(p.s[p.pos : p.pos+n+1])
+		name := p.s[p.pos : p.pos+n]
+		p.pos += n + 1
+		ref, _, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		if !isTestRef(ref) {
+			return nil, fmt.Errorf("test: %s=%s needs a field, i.e. %s=.Cert", name, ref, name)
+		}
+		op = &testOp{Operator: rule, ref: ref}
+	case p.atWord("required"):
+		p.pos += len("required")
+		op = &testOp{Operator: REQUIRED}
+	case p.atWord("notblank"):
+		p.pos += len("notblank")
+		op = &testOp{Operator: NOTBLANK}
+	case p.atWord("unique"), p.atWord("sorted"), p.at("sortedby="):
+		name := "unique"
+		op = &testOp{Operator: UNIQUE}
+		if !p.at(name) {
+			name = "sorted"
+			op.Operator = SORTED
+		}
+		p.pos += len(name)
+		if p.at("by=") || p.at("=") {
+			p.pos += strings.IndexByte(p.s[p.pos:], '=') + 1
+			by, _, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			if !isTestRef("." + by) {
+				return nil, fmt.Errorf("test: %s needs the name of a field, not %q", name, by)
+			}
+			op.ValString = by
+		}
+	case p.at("oneof="):
+		p.pos += len("oneof=")
+		choices, _, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		op = &testOp{Operator: ONEOF, ValString: choices, oneOf: strings.Split(choices, "|")}
+	default:
+		var onLen bool
+		if p.at("len") {
+			p.pos += len("len")
+			onLen = true
+		}
+		for _, o := range testOperators {
+			if p.at(o.s) {
+				op = &testOp{Operator: o.op}
+				p.pos += len(o.s)
+				break
+			}
+		}
+		if op == nil || (onLen && (op.Operator == REGEX || op.Operator == NOTREGEX)) {
+			return nil, fmt.Errorf("invalid test operation %s", p.s[p.pos:])
+		}
+		op.onLen = onLen
+		operand, quoted, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		if len(operand) < 1 && !quoted {
+			return nil, fmt.Errorf("invalid test op - bad operand")
+		}
+		if err = op.setTestOperand(operand, quoted); err != nil {
+			return nil, fmt.Errorf("test: bad operand for test - %s: %s", err.Error(), p.s)
+		}
+		if _, perr := strconv.ParseInt(operand, 10, 64); onLen && perr != nil && len(op.ref) < 1 {
+			return nil, fmt.Errorf("test: len needs a number: %s", p.s)
+		}
+	}
+	p.ret.ops = append(p.ret.ops, op)
+	return &testExpr{kind: testLEAF, leaf: len(p.ret.ops) - 1}, nil
+}
+
+// setTestOperand sets the operand of a test as written in the tag
+func (op *testOp) setTestOperand(operand string, quoted bool) (err error) {
+	switch op.Operator {
+	case REGEX, NOTREGEX:
+		op.ValString = operand
+		op.Regexp, err = regexp.Compile(operand)
+		if err != nil {
+			return fmt.Errorf("regexp failed to compile: %s", err.Error())
+		}
+		return nil
+	}
+	if !quoted && isTestRef(operand) {
+		op.ref = operand
+	}
+	// an = or != can still be a literal, if the operand turns out not to be a field
+	if len(op.ref) < 1 || op.Operator == EQ || op.Operator == NE {
+		return op.setOperand(operand)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package conftagz
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTestExpressions(t *testing.T) {
+	RegisterTestFunc("even", func(val interface{}, fieldname string) bool {
+		return val.(int64)%2 == 0
+	})
+	tests := []struct {
+		tag  string
+		val  interface{}
+		fail string
+	}{
+		{">=1024 || =0", 0, ""},
+		{">=1024 || =0", 8080, ""},
+		{">=1024 || =0", 80, "value 80 ! >= 1024 || value 80 != 0"},
+		{"(>0 && <10) || (>100, <110)", 105, ""},
+		{"(>0 && <10) || (>100, <110)", 50, "value 50 ! < 10 || value 50 ! > 100"},
+		{"!$(even)", 3, ""},
+		{"!$(even)", 4, "value 4 ! !$(even)"},
+		{"!(>=10 && <=20)", 15, "value 15 ! !(>=10 && <=20)"},
+		{"!=0", 0, "value 0 == 0"},
+		{"==prod || ==dev", "dev", ""},
+		{"==prod || ==dev", "test", `value "test" != "prod" || value "test" != "dev"`},
+		{"!=none", "none", "value none == none"},
+		{"!~^tmp, ~'^[a-z,]+$'", "a,b", ""},
+		{"!~^tmp, ~'^[a-z,]+$'", "tmpfile", `value "tmpfile" ~ regexp ^tmp`},
+		{"!~^tmp, ~'^[a-z,]+$'", "A", `value "A" !~ regexp ^[a-z,]+$`},
+		{"~^(a|b){1,2}$ || =c", "ab", ""},
+		{"~^(a|b){1,2}$ || =c", "c", ""},
+		{"=' spaced, out '", " spaced, out ", ""},
+	}
+	for _, tt := range tests {
+		op, err := parseTestVal(tt.tag, defaultProcessor.testFuncs)
+		if !assert.Nil(t, err, tt.tag) {
+			continue
+		}
+		var val interface{} = tt.val
+		if i, ok := val.(int); ok {
+			val = int64(i)
+		}
+		_, err = runTest(op, reflect.ValueOf(val), "V")
+		if len(tt.fail) < 1 {
+			assert.Nil(t, err, tt.tag)
+		} else {
+			assert.EqualError(t, err, tt.fail, tt.tag)
+		}
+	}
+}
+
+func TestTestExpressionsBackwardsCompatible(t *testing.T) {
+	// a tag which is only a regex is still taken whole
+	op, err := parseTestVal("~.{10,}", nil)
+	assert.Nil(t, err)
+	assert.Len(t, op.ops, 1)
+	assert.Equal(t, ".{10,}", op.ops[0].ValString)
+
+	op, err = parseTestVal(">=1024,<65537", nil)
+	assert.Nil(t, err)
+	assert.Len(t, op.ops, 2)
+	assert.Equal(t, testAND, op.expr.kind)
+}
+
+func TestTestExpressionErrors(t *testing.T) {
+	for tag, msg := range map[string]string{
+		"(>0 || <10":    "test: missing ) in (>0 || <10",
+		">0)":           `test: unexpected ")"`,
+		"=='abc":        "test: unterminated quote in =='abc",
+		"$(nosuchfunc)": "test: no test function nosuchfunc",
+		">0 ||":         "invalid test operation ",
+		"~'('":          "test: bad operand for test - regexp failed to compile: error parsing regexp: missing closing ): `(`: ~'('",
+	} {
+		_, err := parseTestVal(tag, nil)
+		assert.EqualError(t, err, msg, tag)
+	}
+}
+
+func TestTestExpressionsInStruct(t *testing.T) {
+	s := struct {
+		Env  string `test:"==prod || ==staging || ==dev"`
+		Port int    `test:"=0 || (>=1024 && <65536)"`
+	}{Env: "prod", Port: 80}
+	_, err := RunTestFlags(&s, nil)
+	assert.EqualError(t, err, "field Port: value 80 != 0 || value 80 ! >= 1024")
+}
+
+func TestTestLengthAndMembership(t *testing.T) {
+	tests := []struct {
+		tag  string
+		val  interface{}
+		fail string
+	}{
+		{"len>=1", "", "length 0 ! >= 1"},
+		{"len>=1", "a", ""},
+		{"len=5", "héllo", ""},
+		{"len<=2", []string{"a", "b", "c"}, "length 3 ! <= 2"},
+		{"len!=0", map[string]int{"a": 1}, ""},
+		{"len>=1 || =none", "", `length 0 ! >= 1 || value "" != "none"`},
+		{"len>=1", 5, "test operator len must be on a string, slice or map field"},
+		{"oneof=debug|info|warn", "info", ""},
+		{"oneof=debug|info|warn", "trace", `value "trace" ! oneof debug|info|warn`},
+		{"oneof='a b|c'", "a b", ""},
+		{"oneof=1|2|3", 2, ""},
+		{"required", "", "value is required"},
+		{"required", []string{}, "value is required"},
+		{"required", 0.5, ""},
+		{"notblank", " \t", "value is blank"},
+		{"notblank", "x", ""},
+		{"!required || oneof=a|b", "", ""},
+	}
+	for _, tt := range tests {
+		op, err := parseTestVal(tt.tag, nil)
+		if !assert.Nil(t, err, tt.tag) {
+			continue
+		}
+		var val interface{} = tt.val
+		if i, ok := val.(int); ok {
+			val = int64(i)
+		}
+		_, err = runTest(op, reflect.ValueOf(val), "V")
+		if len(tt.fail) < 1 {
+			assert.Nil(t, err, tt.tag)
+		} else {
+			assert.EqualError(t, err, tt.fail, tt.tag)
+		}
+	}
+
+	for _, tag := range []string{"len~x", "lenx", "len>=abc", "requiredx"} {
+		_, err := parseTestVal(tag, nil)
+		assert.NotNil(t, err, tag)
+	}
+}
+
+func TestTestRequiredPointersAndSlices(t *testing.T) {
+	type conf struct {
+		Level   *int     `test:"required"`
+		Name    *string  `test:"notblank"`
+		Servers []string `test:"len>=1,len<=5"`
+		Tags    []string `test:"required"`
+	}
+	zero := 0
+	name := "x"
+	s := conf{Level: &zero, Name: &name, Servers: []string{"a"}, Tags: []string{"t"}}
+	_, err := RunTestFlags(&s, nil)
+	assert.Nil(t, err)
+
+	s = conf{}
+	_, err = RunTestFlags(&s, &TestFieldSubstOpts{CollectErrors: true})
+	assert.EqualError(t, err, "4 errors:\n"+
+		"\ttest: field Level: value is required\n"+
+		"\ttest: field Name: value is required\n"+
+		"\ttest: field Servers: length 0 ! >= 1\n"+
+		"\ttest: field Tags: value is required")
+
+	// the nil pointers are left nil, so they fail again
+	_, err = RunTestFlags(&s, nil)
+	assert.EqualError(t, err, "field Level: value is required")
+	assert.Nil(t, s.Level)
+	assert.Nil(t, s.Name)
+
+	// and so do nil pointers to other types
+	s2 := struct {
+		On    *bool          `test:"required"`
+		Ratio *float64       `test:"required"`
+		Count *uint          `test:"notblank"`
+		Wait  *time.Duration `test:"required"`
+	}{}
+	_, err = RunTestFlags(&s2, &TestFieldSubstOpts{CollectErrors: true})
+	assert.EqualError(t, err, "4 errors:\n"+
+		"\ttest: field On: value is required\n"+
+		"\ttest: field Ratio: value is required\n"+
+		"\ttest: field Count: value is required\n"+
+		"\ttest: field Wait: value is required")
+	assert.Nil(t, s2.On)
+}
+
+func TestTestEach(t *testing.T) {
+	s := struct {
+		Ports  []int            `test:"each(>0,<65536)"`
+		Hosts  []string         `test:"len>=1, each(notblank)"`
+		Labels map[string]int   `test:"keys(~^[a-z]+$),values(>=1)"`
+		Limits map[string]*uint `test:"each(<=100)"`
+		Grid   [][]int          `test:"each(each(>=0))"`
+	}{
+		Ports:  []int{80, 443, 0, 70000},
+		Hosts:  []string{"a", " "},
+		Labels: map[string]int{"ok": 0},
+		Grid:   [][]int{{1}, {2, -1}},
+	}
+	_, err := RunTestFlags(&s, &TestFieldSubstOpts{CollectErrors: true})
+	var paths []string
+	var fe *FieldError
+	for _, e := range err.(*MultiError).Errors {
+		if assert.ErrorAs(t, e, &fe) {
+			paths = append(paths, fe.Path+" "+fe.Op+" "+fe.Err.Error())
+		}
+	}
+	assert.Equal(t, []string{
+		"Ports[2] > value 0 ! > 0",
+		"Ports[3] < value 70000 ! < 65536",
+		"Hosts[1] notblank value is blank",
+		"Labels[ok] >= value 0 ! >= 1",
+		"Grid[1][1] >= value -1 ! >= 0",
+	}, paths)
+
+	s.Ports = []int{80}
+	s.Hosts = []string{"a"}
+	s.Labels = nil
+	s.Grid = nil
+	_, err = RunTestFlags(&s, nil)
+	assert.Nil(t, err)
+
+	s.Ports = []int{80, 0}
+	_, err = RunTestFlags(&s, nil)
+	assert.EqualError(t, err, "field Ports[1]: value 0 ! > 0")
+
+	s.Ports = nil
+	s.Labels = map[string]int{"Bad": 1}
+	_, err = RunTestFlags(&s, nil)
+	assert.EqualError(t, err, `field Labels[Bad]: value "Bad" !~ regexp ^[a-z]+$`)
+}
+
+func TestTestEachErrors(t *testing.T) {
+	_, err := parseTestVal("each(>0", nil)
+	assert.EqualError(t, err, "test: missing ) in each(>0")
+
+	s := struct {
+		Port  int      `test:"each(>0)"`
+		Ports []string `test:"keys(notblank)"`
+	}{Port: 1}
+	_, err = RunTestFlags(&s, &TestFieldSubstOpts{CollectErrors: true})
+	assert.EqualError(t, err, "2 errors:\n"+
+		"\ttest: field Port: test each() must be on a slice or map field\n"+
+		"\ttest: field Ports: test keys(notblank) must be on a map field")
+}
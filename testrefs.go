@@ -0,0 +1,86 @@
+package conftagz
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// testRefRE matches an operand in a test: tag which is another field. .MinConns is
+// the field next to the one with the tag, /Limits.MaxConns is a path from the top
+// of the struct.
+var testRefRE = regexp.MustCompile(`^[./][A-Za-z_][A-Za-z0-9_.\[\]]*$`)
+
+func isTestRef(operand string) bool {
+	return testRefRE.MatchString(operand)
+}
+
+// refPath returns the path of the field a reference is to, for a field in the
+// struct at parentpath
+func refPath(parentpath string, ref string) string {
+	if strings.HasPrefix(ref, "/") {
+		return ref[1:]
+	}
+	return addParentPath(parentpath, ref[1:])
+}
+
+// parseRequiredRule parses required_with=.Field and required_without=.Field
+func parseRequiredRule(teststr string) (rule int, ref string, ok bool) {
+	name, ref, ok := strings.Cut(teststr, "=")
+	if !ok {
+		return 0, "", false
+	}
+	switch strings.TrimSpace(name) {
+	case "required_with":
+		return REQUIREDWITH, strings.TrimSpace(ref), true
+	case "required_without":
+		return REQUIREDWITHOUT, strings.TrimSpace(ref), true
+	}
+	return 0, "", false
+}
+
+// resolveTestRefs fills in the operands which are other fields with their current
+// values. op is not changed, as it is shared by every item of a slice or map. An = whose
+// operand is not a field is left as the literal string, so test:"=/var/run" still works.
+func resolveTestRefs(op *testConfOp, root reflect.Value, parentpath string) (*testConfOp, error) {
+	var resolved *testConfOp
+	for n, o := range op.ops {
+		if len(o.ref) < 1 {
+			continue
+		}
+		v, _, ok := op.conv.lookupFieldPath(root, refPath(parentpath, o.ref))
+		if !ok && (o.Operator == EQ || o.Operator == NE) {
+			continue
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s is not a field", o.ref)
+		}
+		if resolved == nil {
+			resolved = &testConfOp{ops: append([]*testOp(nil), op.ops...), expr: op.expr, conv: op.conv}
+		}
+		r := &testOp{Operator: o.Operator, ref: o.ref}
+		switch o.Operator {
+		case REQUIREDWITH, REQUIREDWITHOUT:
+			r.refSet = !isZeroOrNil(derefOrZero(v))
+		default:
+			if err := r.setOperand(op.conv.formatValue(v)); err != nil {
+				return nil, fmt.Errorf("%s: %s", o.ref, err.Error())
+			}
+		}
+		debugf("test: %s is %s\n", o.ref, op.conv.formatValue(v))
+		resolved.ops[n] = r
+	}
+	if resolved == nil {
+		return op, nil
+	}
+	return resolved, nil
+}
+
+// refTestErr adds the field compared against to the error of a failed test
+func refTestErr(op *testOp, err error) error {
+	if err == nil || len(op.ref) < 1 || op.Operator == REQUIREDWITH || op.Operator == REQUIREDWITHOUT {
+		return err
+	}
+	return fmt.Errorf("%s (%s)", err.Error(), op.ref)
+}
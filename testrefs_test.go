@@ -0,0 +1,98 @@
+package conftagz
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type poolLimits struct {
+	MaxConns int `test:">0"`
+}
+
+type poolConf struct {
+	MinConns int `test:">=0"`
+	MaxConns int `test:">=.MinConns,<=/Limits.MaxConns"`
+	Cert     string
+	Key      string `test:"required_with=.Cert"`
+	Password string `test:"required_without=.Cert"`
+}
+
+type poolsConf struct {
+	Limits poolLimits
+	Pools  []poolConf
+	Mode   string `test:"=/var/run"`
+}
+
+func TestTestFieldRefs(t *testing.T) {
+	s := poolsConf{
+		Limits: poolLimits{MaxConns: 100},
+		Pools: []poolConf{
+			{MinConns: 5, MaxConns: 10, Password: "secret"},
+			{MinConns: 5, MaxConns: 50, Cert: "cert.pem", Key: "key.pem"},
+		},
+		Mode: "/var/run",
+	}
+	_, err := RunTestFlags(&s, nil)
+	assert.Nil(t, err)
+
+	s.Pools[0].MaxConns = 2
+	_, err = RunTestFlags(&s, nil)
+	assert.EqualError(t, err, "field Pools[0].MaxConns: value 2 ! >= 5 (.MinConns)")
+
+	s.Pools[0].MaxConns = 200
+	_, err = RunTestFlags(&s, nil)
+	assert.EqualError(t, err, "field Pools[0].MaxConns: value 200 ! <= 100 (/Limits.MaxConns)")
+}
+
+func TestTestRequiredWith(t *testing.T) {
+	s := poolsConf{
+		Limits: poolLimits{MaxConns: 100},
+		Pools:  []poolConf{{Cert: "cert.pem", Password: "secret"}},
+		Mode:   "/var/run",
+	}
+	_, err := RunTestFlags(&s, &TestFieldSubstOpts{CollectErrors: true})
+	assert.EqualError(t, err, "test: field Pools[0].Key: value is required because .Cert is set")
+	var fe *FieldError
+	if assert.ErrorAs(t, err, &fe) {
+		assert.Equal(t, "required_with=.Cert", fe.Op)
+	}
+
+	s.Pools[0] = poolConf{}
+	_, err = RunTestFlags(&s, nil)
+	assert.EqualError(t, err, "field Pools[0].Password: value is required because .Cert is not set")
+}
+
+func TestTestFieldRefErrors(t *testing.T) {
+	s := struct {
+		Max int `test:">=.Nope"`
+	}{}
+	_, err := RunTestFlags(&s, nil)
+	assert.EqualError(t, err, "test tag for field Max: .Nope is not a field (>=.Nope)")
+
+	s2 := struct {
+		Key string `test:"required_with=Cert"`
+	}{}
+	_, err = RunTestFlags(&s2, nil)
+	assert.ErrorContains(t, err, "required_with=Cert needs a field")
+}
+
+func TestTestFieldRefNotNumberQuiet(t *testing.T) {
+	s := struct {
+		Min     time.Duration
+		Timeout time.Duration `test:">=.Min"`
+	}{Min: time.Second, Timeout: time.Minute}
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	_, err = RunTestFlags(&s, nil)
+	os.Stdout = stdout
+	w.Close()
+	assert.Nil(t, err)
+	out, _ := io.ReadAll(r)
+	assert.Empty(t, string(out))
+}
@@ -1,21 +1,35 @@
 package conftagz
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 const (
-	NONE     int = 0
-	EQ       int = iota // =
-	LT                  // <
-	GT                  // >
-	GTE                 // >-
-	LTE                 // <=
-	REGEX               // ~
-	TESTFUNC            // $(funcname)
+	NONE            int = 0
+	EQ              int = iota // =
+	LT                         // <
+	GT                         // >
+	GTE                        // >-
+	LTE                        // <=
+	REGEX                      // ~
+	TESTFUNC                   // $(funcname)
+	REQUIREDWITH               // required_with=.Field
+	REQUIREDWITHOUT            // required_without=.Field
+	NE                         // !=
+	NOTREGEX                   // !~
+	REQUIRED                   // required
+	NOTBLANK                   // notblank
+	ONEOF                      // oneof=a|b|c
+	UNIQUE                     // unique or unique=Field
+	SORTED                     // sorted or sortedby=Field
 )
 
 type testOp struct {
@@ -27,39 +41,123 @@ type testOp struct {
 	testFunc     TestFunc
 	testFuncName string
 	Regexp       *regexp.Regexp
+	// set if the operand is a duration ("1h30m") or an RFC3339 time
+	ValDuration *time.Duration
+	ValTime     *time.Time
+	// false if the operand of a <, >, <= or >= test could only be parsed as a duration or time
+	isNumber bool
+	// the operand is another field, i.e. .MinConns or /Limits.Max. See resolveTestRefs
+	ref string
+	// for REQUIREDWITH and REQUIREDWITHOUT, if the field ref is not zero
+	refSet bool
+	// the comparison is of the length of the value, i.e. len>=1
+	onLen bool
+	// the choices of ONEOF
+	oneOf []string
+}
+
+// String returns the operator as written in a test: tag
+func (op *testOp) String() string {
+	if op.onLen {
+		l := *op
+		l.onLen = false
+		return "len" + l.String()
+	}
+	switch op.Operator {
+	case EQ:
+		return "="
+	case LT:
+		return "<"
+	case GT:
+		return ">"
+	case GTE:
+		return ">="
+	case LTE:
+		return "<="
+	case REGEX:
+		return "~"
+	case TESTFUNC:
+		return "$(" + op.testFuncName + ")"
+	case REQUIREDWITH:
+		return "required_with=" + op.ref
+	case REQUIREDWITHOUT:
+		return "required_without=" + op.ref
+	case NE:
+		return "!="
+	case NOTREGEX:
+		return "!~"
+	case REQUIRED:
+		return "required"
+	case NOTBLANK:
+		return "notblank"
+	case ONEOF:
+		return "oneof=" + strings.Join(op.oneOf, "|")
+	case UNIQUE:
+		if len(op.ValString) > 0 {
+			return "unique=" + op.ValString
+		}
+		return "unique"
+	case SORTED:
+		if len(op.ValString) > 0 {
+			return "sortedby=" + op.ValString
+		}
+		return "sorted"
+	}
+	return ""
 }
 
 type testConfOp struct {
 	ops []*testOp
+	// how the results of ops are combined. See testexpr.go
+	expr *testExpr
+	// the field is a pointer, and if it was nil before RunTestFlags made it to test it
+	ptr    bool
+	nilPtr bool
+	// the converters of the Processor running the test
+	conv converterMap
 }
 
-type TestFunc func(val interface{}, fieldname string) bool
+// forPtr returns op for a pointer field, so required knows if it was set
+func (op *testConfOp) forPtr(isNil bool) *testConfOp {
+	c := *op
+	c.ptr, c.nilPtr = true, isNil
+	return &c
+}
 
-var testFuncs map[string]TestFunc
+type TestFunc func(val interface{}, fieldname string) bool
 
+// RegisterTestFunc registers a TestFunc with the default Processor
 func RegisterTestFunc(id string, f TestFunc) map[string]TestFunc {
-	if testFuncs == nil {
-		testFuncs = make(map[string]TestFunc)
-	}
-	testFuncs[id] = f
-	return testFuncs
+	return defaultProcessor.RegisterTestFunc(id, f)
+}
+
+// RegisterTestFunc registers a TestFunc which can be referenced by a
+// test:"$(id)" tag
+func (p *Processor) RegisterTestFunc(id string, f TestFunc) map[string]TestFunc {
+	p.testFuncs[id] = f
+	return p.testFuncs
 }
 
 type TestWarnPrintf func(format string, args ...interface{})
 
 type TestFieldSubstOpts struct {
-	// throws an error if the environment variable is not found
+	// a failed test is only a warning, as if every field had the conf:"testwarn" tag
 	OnlyWarn bool
+	// prints the warnings for failed tests which only warn. log.Printf if not set.
 	WarnFunc TestWarnPrintf
+	// keep going if a field fails, and return a *MultiError with all the failures
+	CollectErrors bool
 }
 
-var matchTestFuncPat = `^\s*\$\(([a-z,A-Z,_]+[a-z,A-Z,0-9,\_]*)\)\s*$`
-
-var matchTestFuncRE = regexp.MustCompile(matchTestFuncPat)
-
 func runTestFunc(op *testOp, val reflect.Value, fieldName string) (err error) {
 	k := val.Kind()
 	debugf("test TESTFUNC %s\n", op.testFuncName)
+	if val.Type() == durationType {
+		if !op.testFunc(val.Interface(), fieldName) {
+			err = fmt.Errorf("value %s !$(%s)", val.Interface(), op.testFuncName)
+		}
+		return
+	}
 	switch k {
 	case reflect.String:
 		if !op.testFunc(val.String(), fieldName) {
@@ -69,6 +167,10 @@ func runTestFunc(op *testOp, val reflect.Value, fieldName string) (err error) {
 		if !op.testFunc(val.Int(), fieldName) {
 			err = fmt.Errorf("value %d !$(%s)", val.Int(), op.testFuncName)
 		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if !op.testFunc(val.Uint(), fieldName) {
+			err = fmt.Errorf("value %d !$(%s)", val.Uint(), op.testFuncName)
+		}
 	case reflect.Float32, reflect.Float64:
 		if !op.testFunc(val.Float(), fieldName) {
 			err = fmt.Errorf("value %f !$(%s)", val.Float(), op.testFuncName)
@@ -96,378 +198,608 @@ func runTestFunc(op *testOp, val reflect.Value, fieldName string) (err error) {
 	return
 }
 
-func runTest(op *testConfOp, val reflect.Value, fieldName string) (err error) {
-	for _, op := range op.ops {
-		switch op.Operator {
-		case LTE:
-			k := val.Kind()
-			switch k {
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				debugf("test LTE %d\n", op.ValInt)
-				if val.CanInt() {
-					if val.Int() <= op.ValInt {
-					} else {
-						err = fmt.Errorf("value %d ! <= %d", val.Int(), op.ValInt)
-					}
+// runTimeTest runs a comparison test on a time.Duration or time.Time value.
+// handled is false if val is not one of those and the op is not for one.
+func runTimeTest(op *testOp, val reflect.Value) (handled bool, err error) {
+	var cmp int
+	var operand string
+	switch {
+	case val.Type() == durationType:
+		if op.ValDuration == nil {
+			return true, fmt.Errorf("test operand %s not a duration", op.ValString)
+		}
+		d := time.Duration(val.Int())
+		switch {
+		case d < *op.ValDuration:
+			cmp = -1
+		case d > *op.ValDuration:
+			cmp = 1
+		}
+		operand = op.ValDuration.String()
+	case val.Type() == timeType:
+		if op.ValTime == nil {
+			return true, fmt.Errorf("test operand %s not a time (layout %s)", op.ValString, time.RFC3339)
+		}
+		cmp = val.Interface().(time.Time).Compare(*op.ValTime)
+		operand = op.ValTime.Format(time.RFC3339)
+	case !op.isNumber && op.Operator != EQ && op.Operator != NE:
+		return true, fmt.Errorf("test operand %s not a number", op.ValString)
+	default:
+		return false, nil
+	}
+	var ok bool
+	switch op.Operator {
+	case EQ:
+		ok = cmp == 0
+	case NE:
+		ok = cmp != 0
+	case LT:
+		ok = cmp < 0
+	case GT:
+		ok = cmp > 0
+	case LTE:
+		ok = cmp <= 0
+	case GTE:
+		ok = cmp >= 0
+	}
+	if !ok {
+		err = fmt.Errorf("value %v ! %s %s", val.Interface(), op.String(), operand)
+	}
+	return true, err
+}
+
+// runTest runs all the tests in op against val. If a test fails the failing
+// testOp is returned along with the error. failed is nil if the failure is of
+// a || or ! of several tests.
+func runTest(op *testConfOp, val reflect.Value, fieldName string) (failed *testOp, err error) {
+	return op.run(op.expr, val, fieldName)
+}
+
+func (op *testConfOp) run(e *testExpr, val reflect.Value, fieldName string) (failed *testOp, err error) {
+	switch e.kind {
+	case testAND:
+		for _, arg := range e.args {
+			if failed, err = op.run(arg, val, fieldName); err != nil {
+				return
+			}
+		}
+		return nil, nil
+	case testOR:
+		var fails []string
+		for _, arg := range e.args {
+			if _, err = op.run(arg, val, fieldName); err == nil {
+				return nil, nil
+			}
+			fails = append(fails, err.Error())
+		}
+		return nil, fmt.Errorf("%s", strings.Join(fails, " || "))
+	case testNOT:
+		if _, err = op.run(e.args[0], val, fieldName); err == nil {
+			return nil, fmt.Errorf("value %v ! %s", valueForError(val), e.src)
+		}
+		return nil, nil
+	case testEACH, testKEYS, testVALUES:
+		return nil, op.runItems(e, val, fieldName)
+	}
+	t := op.ops[e.leaf]
+	if op.ptr && (t.Operator == REQUIRED || t.Operator == NOTBLANK) && op.nilPtr {
+		return t, fmt.Errorf("value is required")
+	}
+	if op.ptr && t.Operator == REQUIRED {
+		// a pointer which is set, even to a zero value
+		return nil, nil
+	}
+	if err = runTestOp(op.conv, t, val, fieldName); err != nil {
+		return t, err
+	}
+	return nil, nil
+}
+
+// itemError is a failed test of one item of a slice or map
+type itemError struct {
+	// added to the path of the field, i.e. [3] or [primary]
+	index  string
+	failed *testOp
+	value  reflect.Value
+	err    error
+}
+
+// itemErrors are the failed items of an each(), keys() or values() test
+type itemErrors []*itemError
+
+func (e itemErrors) Error() string {
+	var msgs []string
+	for _, item := range e {
+		msgs = append(msgs, item.index+": "+item.err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// runItems runs the test of an each(), keys() or values() on every item of val
+func (op *testConfOp) runItems(e *testExpr, val reflect.Value, fieldName string) error {
+	// the items are not the field, so are not the pointer it may be
+	inner := &testConfOp{ops: op.ops, expr: op.expr, conv: op.conv}
+	var items itemErrors
+	check := func(index string, item reflect.Value) {
+		item = derefOrZero(item)
+		if failed, err := inner.run(e.args[0], item, fieldName); err != nil {
+			items = append(items, &itemError{index: index, failed: failed, value: item, err: err})
+		}
+	}
+	val = derefOrZero(val)
+	switch {
+	case e.kind == testEACH && (val.Kind() == reflect.Slice || val.Kind() == reflect.Array):
+		for n := 0; n < val.Len(); n++ {
+			check(fmt.Sprintf("[%d]", n), val.Index(n))
+		}
+	case val.Kind() == reflect.Map:
+		for _, key := range sortedMapKeys(val) {
+			if e.kind == testKEYS {
+				check(mapPath("", op.conv.formatValue(key)), key)
+			} else {
+				check(mapPath("", op.conv.formatValue(key)), val.MapIndex(key))
+			}
+		}
+	case e.kind == testEACH:
+		return fmt.Errorf("test each() must be on a slice or map field")
+	default:
+		return fmt.Errorf("test %s must be on a map field", e.src)
+	}
+	if len(items) > 0 {
+		return items
+	}
+	return nil
+}
+
+// valueForError is val as printed in the error of a failed test
+func valueForError(val reflect.Value) interface{} {
+	if val.IsValid() && val.CanInterface() {
+		return val.Interface()
+	}
+	return val
+}
+
+// valueLen is the length of a string (in characters), slice, array or map
+func valueLen(val reflect.Value) (int, bool) {
+	val = derefOrZero(val)
+	switch val.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(val.String()), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return val.Len(), true
+	}
+	return 0, false
+}
+
+// runTestOp runs a single test against val
+func runTestOp(conv converterMap, op *testOp, val reflect.Value, fieldName string) (err error) {
+	if op.onLen {
+		n, ok := valueLen(val)
+		if !ok {
+			return fmt.Errorf("test operator len must be on a string, slice or map field")
+		}
+		l := *op
+		l.onLen = false
+		if runTestOp(conv, &l, reflect.ValueOf(int64(n)), fieldName) != nil {
+			err = fmt.Errorf("length %d ! %s %d", n, l.String(), l.ValInt)
+		}
+		return refTestErr(op, err)
+	}
+	switch op.Operator {
+	case EQ, NE, LT, GT, LTE, GTE:
+		var handled bool
+		if handled, err = runTimeTest(op, val); handled {
+			return refTestErr(op, err)
+		}
+	}
+	switch op.Operator {
+	case LTE:
+		k := val.Kind()
+		switch k {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			debugf("test LTE %d\n", op.ValInt)
+			if val.CanInt() {
+				if val.Int() <= op.ValInt {
 				} else {
-					err = fmt.Errorf("value for field - can't get int64")
+					err = fmt.Errorf("value %d ! <= %d", val.Int(), op.ValInt)
 				}
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				debugf("test LTE %d\n", op.ValUint)
-				if val.CanUint() {
-					if val.Uint() <= op.ValUint {
-					} else {
-						err = fmt.Errorf("value %d ! <= %d", val.Uint(), op.ValUint)
-					}
+			} else {
+				err = fmt.Errorf("value for field - can't get int64")
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			debugf("test LTE %d\n", op.ValUint)
+			if val.CanUint() {
+				if val.Uint() <= op.ValUint {
 				} else {
-					err = fmt.Errorf("value for field - can't get uint64")
+					err = fmt.Errorf("value %d ! <= %d", val.Uint(), op.ValUint)
 				}
-			case reflect.Float32, reflect.Float64:
-				debugf("test LTE %f\n", op.ValFloat)
-				if val.CanInt() {
-					if val.Float() <= op.ValFloat {
-					} else {
-						err = fmt.Errorf("value %f ! <= %f", val.Float(), op.ValFloat)
-					}
+			} else {
+				err = fmt.Errorf("value for field - can't get uint64")
+			}
+		case reflect.Float32, reflect.Float64:
+			debugf("test LTE %f\n", op.ValFloat)
+			if val.CanInt() {
+				if val.Float() <= op.ValFloat {
 				} else {
-					err = fmt.Errorf("value for field - can't get float64")
+					err = fmt.Errorf("value %f ! <= %f", val.Float(), op.ValFloat)
 				}
-			default:
-				err = fmt.Errorf("test operator require numeric type")
+			} else {
+				err = fmt.Errorf("value for field - can't get float64")
 			}
-		case GTE:
-			k := val.Kind()
-			switch k {
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				debugf("test GTE %d\n", op.ValInt)
-				if val.CanInt() {
-					if val.Int() >= op.ValInt {
-					} else {
-						err = fmt.Errorf("value %d ! >= %d", val.Int(), op.ValInt)
-					}
+		default:
+			err = fmt.Errorf("test operator require numeric type")
+		}
+	case GTE:
+		k := val.Kind()
+		switch k {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			debugf("test GTE %d\n", op.ValInt)
+			if val.CanInt() {
+				if val.Int() >= op.ValInt {
 				} else {
-					err = fmt.Errorf("value for field - can't get int64")
+					err = fmt.Errorf("value %d ! >= %d", val.Int(), op.ValInt)
 				}
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				debugf("test GTE %d\n", op.ValUint)
-				if val.CanUint() {
-					if val.Uint() >= op.ValUint {
-					} else {
-						err = fmt.Errorf("value %d ! >= %d", val.Uint(), op.ValUint)
-					}
+			} else {
+				err = fmt.Errorf("value for field - can't get int64")
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			debugf("test GTE %d\n", op.ValUint)
+			if val.CanUint() {
+				if val.Uint() >= op.ValUint {
 				} else {
-					err = fmt.Errorf("value for field - can't get uint64")
+					err = fmt.Errorf("value %d ! >= %d", val.Uint(), op.ValUint)
 				}
-			case reflect.Float32, reflect.Float64:
-				debugf("test GTE %f\n", op.ValFloat)
-				if val.CanInt() {
-					if val.Float() >= op.ValFloat {
-					} else {
-						err = fmt.Errorf("value %f ! >= %f", val.Float(), op.ValFloat)
-					}
+			} else {
+				err = fmt.Errorf("value for field - can't get uint64")
+			}
+		case reflect.Float32, reflect.Float64:
+			debugf("test GTE %f\n", op.ValFloat)
+			if val.CanInt() {
+				if val.Float() >= op.ValFloat {
 				} else {
-					err = fmt.Errorf("value for field - can't get float64")
+					err = fmt.Errorf("value %f ! >= %f", val.Float(), op.ValFloat)
 				}
-			default:
-				err = fmt.Errorf("test operator require numeric type")
+			} else {
+				err = fmt.Errorf("value for field - can't get float64")
 			}
+		default:
+			err = fmt.Errorf("test operator require numeric type")
+		}
 
-		case LT:
-			k := val.Kind()
-			switch k {
-			// TODO - add support for Ptr to String and Ints
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				debugf("test LT %d\n", op.ValInt)
-				if val.CanInt() {
-					if val.Int() < op.ValInt {
-					} else {
-						err = fmt.Errorf("value %d ! < %d", val.Int(), op.ValInt)
-					}
-				} else {
-					err = fmt.Errorf("value for field - can't get int64")
-				}
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				debugf("test LT %d\n", op.ValUint)
-				if val.CanUint() {
-					if val.Uint() < op.ValUint {
-					} else {
-						err = fmt.Errorf("value %d ! < %d", val.Uint(), op.ValUint)
-					}
+	case LT:
+		k := val.Kind()
+		switch k {
+		// TODO - add support for Ptr to String and Ints
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			debugf("test LT %d\n", op.ValInt)
+			if val.CanInt() {
+				if val.Int() < op.ValInt {
 				} else {
-					err = fmt.Errorf("value for field - can't get uint64")
+					err = fmt.Errorf("value %d ! < %d", val.Int(), op.ValInt)
 				}
-			case reflect.Float32, reflect.Float64:
-				debugf("test LT %f\n", op.ValFloat)
-				if val.CanInt() {
-					if val.Float() < op.ValFloat {
-					} else {
-						err = fmt.Errorf("value %f ! < %f", val.Float(), op.ValFloat)
-					}
+			} else {
+				err = fmt.Errorf("value for field - can't get int64")
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			debugf("test LT %d\n", op.ValUint)
+			if val.CanUint() {
+				if val.Uint() < op.ValUint {
 				} else {
-					err = fmt.Errorf("value for field - can't get float64")
+					err = fmt.Errorf("value %d ! < %d", val.Uint(), op.ValUint)
 				}
-			default:
-				err = fmt.Errorf("test operator require numeric type")
+			} else {
+				err = fmt.Errorf("value for field - can't get uint64")
 			}
-		case GT:
-			k := val.Kind()
-			switch k {
-			// TODO - add support for Ptr to String and Ints
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				debugf("test GT %d\n", op.ValInt)
-				if val.CanInt() {
-					if val.Int() > op.ValInt {
-					} else {
-						err = fmt.Errorf("value %d ! > %d", val.Int(), op.ValInt)
-					}
+		case reflect.Float32, reflect.Float64:
+			debugf("test LT %f\n", op.ValFloat)
+			if val.CanInt() {
+				if val.Float() < op.ValFloat {
 				} else {
-					err = fmt.Errorf("value for field - can't get int64")
+					err = fmt.Errorf("value %f ! < %f", val.Float(), op.ValFloat)
 				}
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				debugf("test GT %d\n", op.ValUint)
-				if val.CanUint() {
-					if val.Uint() > op.ValUint {
-					} else {
-						err = fmt.Errorf("value %d ! > %d", val.Int(), op.ValUint)
-					}
+			} else {
+				err = fmt.Errorf("value for field - can't get float64")
+			}
+		default:
+			err = fmt.Errorf("test operator require numeric type")
+		}
+	case GT:
+		k := val.Kind()
+		switch k {
+		// TODO - add support for Ptr to String and Ints
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			debugf("test GT %d\n", op.ValInt)
+			if val.CanInt() {
+				if val.Int() > op.ValInt {
 				} else {
-					err = fmt.Errorf("value for field - can't get uint64")
+					err = fmt.Errorf("value %d ! > %d", val.Int(), op.ValInt)
 				}
-			case reflect.Float32, reflect.Float64:
-				debugf("test GT %f\n", op.ValFloat)
-				if val.CanFloat() {
-					if val.Float() > op.ValFloat {
-					} else {
-						err = fmt.Errorf("value %f ! > %f", val.Float(), op.ValFloat)
-					}
+			} else {
+				err = fmt.Errorf("value for field - can't get int64")
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			debugf("test GT %d\n", op.ValUint)
+			if val.CanUint() {
+				if val.Uint() > op.ValUint {
 				} else {
-					err = fmt.Errorf("value for field - can't get float64")
+					err = fmt.Errorf("value %d ! > %d", val.Int(), op.ValUint)
 				}
-			default:
-				err = fmt.Errorf("test operator require numeric type")
+			} else {
+				err = fmt.Errorf("value for field - can't get uint64")
 			}
-		case EQ:
-			k := val.Kind()
-			switch k {
-			// TODO - add support for Ptr to String and Ints
-			case reflect.String:
-				debugf("test EQ %s\n", op.ValString)
-				if val.String() == op.ValString {
-					return
+		case reflect.Float32, reflect.Float64:
+			debugf("test GT %f\n", op.ValFloat)
+			if val.CanFloat() {
+				if val.Float() > op.ValFloat {
 				} else {
-					err = fmt.Errorf("value \"%s\" != \"%s\"", val.String(), op.ValString)
+					err = fmt.Errorf("value %f ! > %f", val.Float(), op.ValFloat)
 				}
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				debugf("test EQ %d\n", op.ValInt)
-				if val.CanInt() {
-					if val.Int() == op.ValInt {
-					} else {
-						err = fmt.Errorf("value %d != %d", val.Int(), op.ValInt)
-					}
+			} else {
+				err = fmt.Errorf("value for field - can't get float64")
+			}
+		default:
+			err = fmt.Errorf("test operator require numeric type")
+		}
+	case EQ:
+		k := val.Kind()
+		switch k {
+		// TODO - add support for Ptr to String and Ints
+		case reflect.String:
+			debugf("test EQ %s\n", op.ValString)
+			if val.String() == op.ValString {
+				return
+			} else {
+				err = fmt.Errorf("value \"%s\" != \"%s\"", val.String(), op.ValString)
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			debugf("test EQ %d\n", op.ValInt)
+			if val.CanInt() {
+				if val.Int() == op.ValInt {
 				} else {
-					err = fmt.Errorf("value for field - can't get int64")
+					err = fmt.Errorf("value %d != %d", val.Int(), op.ValInt)
 				}
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				debugf("test EQ %d\n", op.ValUint)
-				if val.CanUint() {
-					if val.Uint() == op.ValUint {
-					} else {
-						err = fmt.Errorf("value %d != %d", val.Int(), op.ValUint)
-					}
+			} else {
+				err = fmt.Errorf("value for field - can't get int64")
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			debugf("test EQ %d\n", op.ValUint)
+			if val.CanUint() {
+				if val.Uint() == op.ValUint {
 				} else {
-					err = fmt.Errorf("value for field - can't get uint64")
+					err = fmt.Errorf("value %d != %d", val.Int(), op.ValUint)
 				}
-			case reflect.Float32, reflect.Float64:
-				debugf("test EQ %f\n", op.ValFloat)
-				if val.CanFloat() {
-					if val.Float() == op.ValFloat {
-					} else {
-						err = fmt.Errorf("value %f != %f", val.Float(), op.ValFloat)
-					}
+			} else {
+				err = fmt.Errorf("value for field - can't get uint64")
+			}
+		case reflect.Float32, reflect.Float64:
+			debugf("test EQ %f\n", op.ValFloat)
+			if val.CanFloat() {
+				if val.Float() == op.ValFloat {
 				} else {
-					err = fmt.Errorf("value for field - can't get float64")
+					err = fmt.Errorf("value %f != %f", val.Float(), op.ValFloat)
 				}
-			default:
-				err = fmt.Errorf("test operator = unsupported type")
+			} else {
+				err = fmt.Errorf("value for field - can't get float64")
 			}
-		case REGEX:
-			k := val.Kind()
-			switch k {
-			case reflect.String:
-				debugf("test REGEX %s\n", op.ValString)
-				if !op.Regexp.Match([]byte(val.String())) {
-					err = fmt.Errorf("value \"%s\" !~ regexp %s", val.String(), op.ValString)
-				}
-			default:
-				err = fmt.Errorf("value for field - REGEX test operator must be on a string or string* field")
+		default:
+			err = fmt.Errorf("test operator = unsupported type")
+		}
+	case REGEX:
+		k := val.Kind()
+		switch k {
+		case reflect.String:
+			debugf("test REGEX %s\n", op.ValString)
+			if !op.Regexp.Match([]byte(val.String())) {
+				err = fmt.Errorf("value \"%s\" !~ regexp %s", val.String(), op.ValString)
 			}
-		case TESTFUNC:
-			err = runTestFunc(op, val, fieldName)
-			// k := val.Kind()
-			// debugf("test TESTFUNC %s\n", op.testFuncName)
-			// switch k {
-			// case reflect.String:
-			// 	if !op.testFunc(val.String(), fieldName) {
-			// 		err = fmt.Errorf("value %s !$(%s)", val.String(), op.testFuncName)
-			// 	}
-			// case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			// 	if !op.testFunc(val.Int(), fieldName) {
-			// 		err = fmt.Errorf("value %d !$(%s)", val.Int(), op.testFuncName)
-			// 	}
-			// case reflect.Float32, reflect.Float64:
-			// 	if !op.testFunc(val.Float(), fieldName) {
-			// 		err = fmt.Errorf("value %f !$(%s)", val.Float(), op.testFuncName)
-			// 	}
-			// case reflect.Bool:
-			// 	if !op.testFunc(val.Bool(), fieldName) {
-			// 		err = fmt.Errorf("value %t !$(%s)", val.Bool(), op.testFuncName)
-			// 	}
-			// case reflect.Ptr:
-			// 	if !op.testFunc(val.Interface(), fieldName) {
-			// 		err = fmt.Errorf("value %s !$(%s)", val.String(), op.testFuncName)
-			// 	}
+		default:
+			err = fmt.Errorf("value for field - REGEX test operator must be on a string or string* field")
+		}
+	case TESTFUNC:
+		err = runTestFunc(op, val, fieldName)
+		// k := val.Kind()
+		// debugf("test TESTFUNC %s\n", op.testFuncName)
+		// switch k {
+		// case reflect.String:
+		// 	if !op.testFunc(val.String(), fieldName) {
+		// 		err = fmt.Errorf("value %s !$(%s)", val.String(), op.testFuncName)
+		// 	}
+		// case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// 	if !op.testFunc(val.Int(), fieldName) {
+		// 		err = fmt.Errorf("value %d !$(%s)", val.Int(), op.testFuncName)
+		// 	}
+		// case reflect.Float32, reflect.Float64:
+		// 	if !op.testFunc(val.Float(), fieldName) {
+		// 		err = fmt.Errorf("value %f !$(%s)", val.Float(), op.testFuncName)
+		// 	}
+		// case reflect.Bool:
+		// 	if !op.testFunc(val.Bool(), fieldName) {
+		// 		err = fmt.Errorf("value %t !$(%s)", val.Bool(), op.testFuncName)
+		// 	}
+		// case reflect.Ptr:
+		// 	if !op.testFunc(val.Interface(), fieldName) {
+		// 		err = fmt.Errorf("value %s !$(%s)", val.String(), op.testFuncName)
+		// 	}
 
-			// }
+		// }
+	case REQUIREDWITH:
+		if op.refSet && isZeroOrNil(val) {
+			err = fmt.Errorf("value is required because %s is set", op.ref)
+		}
+	case REQUIREDWITHOUT:
+		if !op.refSet && isZeroOrNil(val) {
+			err = fmt.Errorf("value is required because %s is not set", op.ref)
+		}
+	case NE:
+		var equal bool
+		if equal, err = testEqual(op, val); err == nil && equal {
+			err = fmt.Errorf("value %v == %s", val.Interface(), op.ValString)
+		}
+	case REQUIRED:
+		if isZeroOrNil(val) {
+			err = fmt.Errorf("value is required")
+		}
+	case NOTBLANK:
+		if val.Kind() == reflect.String && len(strings.TrimSpace(val.String())) < 1 {
+			err = fmt.Errorf("value is blank")
+		} else if isZeroOrNil(val) {
+			err = fmt.Errorf("value is required")
+		}
+	case UNIQUE:
+		err = runUnique(conv, op, val)
+	case SORTED:
+		err = runSorted(conv, op, val)
+	case ONEOF:
+		s := conv.formatValue(val)
+		err = fmt.Errorf("value %q ! oneof %s", s, strings.Join(op.oneOf, "|"))
+		for _, choice := range op.oneOf {
+			if s == choice {
+				err = nil
+				break
+			}
+		}
+	case NOTREGEX:
+		if val.Kind() != reflect.String {
+			err = fmt.Errorf("value for field - REGEX test operator must be on a string or string* field")
+		} else if op.Regexp.MatchString(val.String()) {
+			err = fmt.Errorf("value \"%s\" ~ regexp %s", val.String(), op.ValString)
 		}
 	}
-	return
+	return refTestErr(op, err)
 }
 
-func parseTestVal(tagval string) (ret *testConfOp, err error) {
-	// split tagval by ','
-	var op *testOp
-	tagval = strings.TrimSpace(tagval)
-
-	// regex can contain commas and there is no point in combining it with other tests
-	// so single it out
-	if strings.HasPrefix(tagval, "~") {
-		parts := strings.Split(tagval, "~")
-		op := &testOp{Operator: REGEX, ValString: parts[1]}
-		op.Regexp, err = regexp.Compile(op.ValString)
-		if err != nil {
-			err = fmt.Errorf("test: regexp failed to compile: %s", err.Error())
-		} else {
-			ret = &testConfOp{}
-			ret.ops = append(ret.ops, op)
-		}
-	} else {
-
-		vals := strings.Split(tagval, ",")
-
-		for _, teststr := range vals {
-			// check if this is a test function
-			var f TestFunc
-			matches := matchTestFuncRE.FindAllStringSubmatch(teststr, -1)
-			if len(matches) > 0 {
-				if len(matches[0]) > 1 {
-					debugf("test: Found a default func (if Ptr nil): %s\n", matches[0][1])
-					f = testFuncs[matches[0][1]]
-				}
-			}
+// testEqual compares val with the operand of a != test
+func testEqual(op *testOp, val reflect.Value) (bool, error) {
+	switch val.Kind() {
+	case reflect.String:
+		return val.String() == op.ValString, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int() == op.ValInt, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return val.Uint() == op.ValUint, nil
+	case reflect.Float32, reflect.Float64:
+		return val.Float() == op.ValFloat, nil
+	}
+	return false, fmt.Errorf("test operator != unsupported type")
+}
 
-			if f != nil {
-				op = &testOp{Operator: TESTFUNC, testFunc: f, testFuncName: matches[0][1]}
-			} else {
-				// not a testfunc, so parse for other tests
-				// remove leading and trailing spaces
-				teststr = strings.TrimSpace(teststr)
-				var opn int
-				var n int
-				var c rune
-			parsetest:
-				for n, c = range teststr {
-					switch c {
-					case ' ':
-						continue parsetest
-					case '<':
-						opn = LT
-						continue parsetest
-					case '>':
-						opn = GT
-						continue parsetest
-					case '~':
-						err = fmt.Errorf("test: regex may not be combined with other tests")
-						return
-						// opn = REGEX
-						// break parsetest
-						// regex
-					case '=':
-						if opn == LT {
-							opn = LTE
-							break parsetest
-						} else if opn == GT {
-							opn = GTE
-							break parsetest
-						} else {
-							opn = EQ
-							break parsetest
-						}
-					default:
-						switch opn {
-						case LT, GT:
-							n--
-						default:
-							err = fmt.Errorf("invalid test operation")
-							return
-						}
-						break parsetest
-					}
-				}
-				if n+1 > len(teststr) {
-					err = fmt.Errorf("invalid test op - bad operand")
-					return
-				}
-				op = &testOp{Operator: opn, ValString: teststr[n+1:]}
-				debugf("test: ValString: %s\n", op.ValString)
-				switch opn {
-				case EQ:
-					val, err := StringToInt64(op.ValString)
-					if err == nil {
-						op.ValInt = val
-					}
-					valu, err := StringToUint64(op.ValString)
-					if err == nil {
-						op.ValUint = valu
-					}
-					valf, err := StringToFloat64(op.ValString)
-					if err == nil {
-						op.ValFloat = valf
-					}
-				// case REGEX:
-				// 	op.Regexp, err = regexp.Compile(op.ValString)
-				// 	if err != nil {
-				// 		err = fmt.Errorf("test: regexp failed to compile: %s", err.Error())
-				// 	}
-				case LTE, GTE, LT, GT:
-					var valu uint64
-					valu, err = StringToUint64(op.ValString)
-					if err == nil {
-						op.ValUint = valu
-					}
-					val, err2 := StringToInt64(op.ValString)
-					if err2 == nil {
-						op.ValInt = val
-					}
-					if err != nil && err2 != nil {
-						err = fmt.Errorf("test: bad operand for test - could not coerce number: %s", tagval)
-						return
-					}
-				}
-			}
-			if ret == nil {
-				ret = &testConfOp{}
+// setOperand parses the operand of a comparison, i.e. the 10 of >=10
+func (op *testOp) setOperand(operand string) (err error) {
+	op.ValString = operand
+	debugf("test: ValString: %s\n", op.ValString)
+	if d, derr := time.ParseDuration(op.ValString); derr == nil {
+		op.ValDuration = &d
+	}
+	if t, terr := time.Parse(time.RFC3339, op.ValString); terr == nil {
+		op.ValTime = &t
+	}
+	switch op.Operator {
+	case EQ, NE:
+		val, err := strconv.ParseInt(op.ValString, 10, 64)
+		if err == nil {
+			op.ValInt = val
+		}
+		valu, err := strconv.ParseUint(op.ValString, 10, 64)
+		if err == nil {
+			op.ValUint = valu
+		}
+		valf, err := strconv.ParseFloat(op.ValString, 64)
+		if err == nil {
+			op.ValFloat = valf
+		}
+	case LTE, GTE, LT, GT:
+		var valu uint64
+		valu, err = strconv.ParseUint(op.ValString, 10, 64)
+		if err == nil {
+			op.ValUint = valu
+		}
+		val, err2 := strconv.ParseInt(op.ValString, 10, 64)
+		if err2 == nil {
+			op.ValInt = val
+		}
+		op.isNumber = err == nil || err2 == nil
+		if !op.isNumber {
+			if op.ValDuration == nil && op.ValTime == nil {
+				return fmt.Errorf("could not coerce number")
 			}
-			// op is guaranteed to be non-nil at this stage
-			// testtag.go:460:7: SA4031: this nil check is always true (staticcheck)
-			// if op != nil {
-			ret.ops = append(ret.ops, op)
+			err = nil
 		}
 	}
 	return
 }
 
+func parseTestVal(tagval string, testFuncs map[string]TestFunc) (ret *testConfOp, err error) {
+	tagval = strings.TrimSpace(tagval)
+
+	// a tag which is only a regex can have commas, | and ( in it, as it always could.
+	// Quote it, i.e. ~'^a.*', to combine it with other tests.
+	if strings.HasPrefix(tagval, "~") && !strings.HasPrefix(tagval, "~'") {
+		op := &testOp{Operator: REGEX}
+		if err = op.setTestOperand(tagval[1:], true); err != nil {
+			return nil, fmt.Errorf("test: %s", err.Error())
+		}
+		return &testConfOp{ops: []*testOp{op}, expr: &testExpr{kind: testLEAF}}, nil
+	}
+
+	p := &testExprParser{s: tagval, testFuncs: testFuncs, ret: &testConfOp{}}
+	p.ret.expr, err = p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return p.ret, nil
+}
+
 // Runs through all test:"" tags to see if the current value passes the test
+// using the default Processor
 func RunTestFlags(somestruct interface{}, opts *TestFieldSubstOpts) (ret []string, err error) {
+	return defaultProcessor.RunTestFlags(somestruct, opts)
+}
+
+// Runs through all test:"" tags to see if the current value passes the test
+func (p *Processor) RunTestFlags(somestruct interface{}, opts *TestFieldSubstOpts) (ret []string, err error) {
+	conv := p.converters
+	var collect bool
+	if opts != nil {
+		collect = opts.CollectErrors
+	}
+	errs := newErrorCollector(STAGETEST, collect, somestruct)
+
+	warnf := log.Printf
+	if opts != nil && opts.WarnFunc != nil {
+		warnf = opts.WarnFunc
+	}
+
+	// testFailed records the failed test for the field at path. If the field is tagged
+	// conf:"testwarn" the failure is only printed as a warning. The items which failed
+	// an each() are each recorded with their own path, i.e. Ports[3].
+	// Returns an error only if the run should stop.
+	var testFailed func(path string, testval string, failed *testOp, val reflect.Value, confops map[string]string, err error) error
+	testFailed = func(path string, testval string, failed *testOp, val reflect.Value, confops map[string]string, err error) error {
+		var items itemErrors
+		if errors.As(err, &items) {
+			for _, item := range items {
+				if err := testFailed(path+item.index, testval, item.failed, item.value, confops, item.err); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		fe := &FieldError{Path: path, Tag: testval, Err: err}
+		if failed != nil {
+			fe.Op = failed.String()
+		}
+		if val.IsValid() && val.CanInterface() {
+			fe.Value = val.Interface()
+		}
+		if testWarn(confops) || (opts != nil && opts.OnlyWarn) {
+			warnf("warning: %s\n", fe.Error())
+			return nil
+		}
+		return errs.addField(fe)
+	}
+
+	// fields like .MinConns in a test: tag are looked up from here
+	root := reflect.ValueOf(somestruct)
 
 	var innerTest func(parentpath string, somestruct interface{}) (err error)
 
@@ -510,11 +842,25 @@ func RunTestFlags(somestruct interface{}, opts *TestFieldSubstOpts) (ret []strin
 			var op *testConfOp
 			// parse testval
 			if len(testval) > 0 {
-				op, err = parseTestVal(testval)
+				op, err = parseTestVal(testval, p.testFuncs)
 				if err != nil {
+					if errs.collect {
+						errs.add(addParentPath(parentpath, field.Name), testval, nil, fmt.Errorf("parse error for test tag: %s (%s)", err.Error(), testval))
+						continue
+					}
 					err = fmt.Errorf("parse error for test tag for field %s: %s (%s)", addParentPath(parentpath, field.Name), err.Error(), testval)
 					return
 				}
+				op.conv = conv
+				op, err = resolveTestRefs(op, root, parentpath)
+				if err != nil {
+					if errs.collect {
+						errs.add(addParentPath(parentpath, field.Name), testval, nil, err)
+						continue
+					}
+					err = fmt.Errorf("test tag for field %s: %s (%s)", addParentPath(parentpath, field.Name), err.Error(), testval)
+					return
+				}
 			}
 
 			debugf("test: Field Name: %s, Test op: %s\n", field.Name, testval)
@@ -523,7 +869,7 @@ func RunTestFlags(somestruct interface{}, opts *TestFieldSubstOpts) (ret []strin
 			fieldValue := inputValue.FieldByName(field.Name)
 			// Only do substitution if the field value can be changed
 
-			if field.Type.Kind() == reflect.Ptr || field.Type.Kind() == reflect.Slice {
+			if (field.Type.Kind() == reflect.Ptr || field.Type.Kind() == reflect.Slice) && !conv.isConvertedType(field.Type) {
 				// recurse
 				fieldValue := inputValue.FieldByName(field.Name)
 				// used to create a temp Value of any kind, based on the underlying
@@ -532,23 +878,33 @@ func RunTestFlags(somestruct interface{}, opts *TestFieldSubstOpts) (ret []strin
 				// if the type is a string or number we create it only if we have a default
 				// value
 				t := fieldValue.Type()
+				wasNil := fieldValue.IsNil()
 				if fieldValue.IsNil() {
 					debugf("test: Field %s is nil\n", field.Name)
 					if skipIfNil(confops) {
 						continue
 					}
-					switch t.Elem().Kind() {
-					case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-						if field.Type.Kind() == reflect.Ptr {
-							debugf("test: Ptr: Underlying fundamental type: %s\n", t.Elem().Kind().String())
-							if op != nil {
-								fieldValue.Set(reflect.New(t.Elem()))
+					if field.Type.Kind() == reflect.Ptr && conv.isScalarType(t.Elem()) {
+						// a nil pointer to a single value is tested as its zero value, but is
+						// left nil, so required still fails if the tests are run again
+						if op != nil {
+							if skipIfZero(confops) {
+								debugf("test: skip zero (test) nil\n")
+								continue
 							}
+							zero := reflect.Zero(t.Elem())
+							var failed *testOp
+							failed, err = runTest(op.forPtr(true), zero, field.Name)
+							if err != nil {
+								if err = testFailed(addParentPath(parentpath, field.Name), testval, failed, zero, confops, err); err != nil {
+									return
+								}
+							}
+							ret = append(ret, addParentPath(parentpath, field.Name))
 						}
-						// if field.Type.Kind() == reflect.Slice {
-						// 	debugf("Slice: Underlying fundamental type: %s\n", t.Elem().Kind().String())
-						// 	fieldValue.Set(reflect.MakeSlice(fieldValue.Type(), 0, 0))
-						// }
+						continue
+					}
+					switch t.Elem().Kind() {
 					case reflect.Struct:
 						debugf("test: Ptr: Underlying struct type: %s\n", t.Elem().Kind().String())
 						if field.Type.Kind() == reflect.Ptr { // i.e. not a slice
@@ -563,7 +919,7 @@ func RunTestFlags(somestruct interface{}, opts *TestFieldSubstOpts) (ret []strin
 					// 	fieldValue.Set(reflect.MakeSlice(fieldValue.Type().Elem(), 0, 0))
 					default:
 						debugf("test: test for %s underlying type unsupported\n", field.Name)
-						if op != nil {
+						if op != nil && field.Type.Kind() == reflect.Ptr {
 							err = fmt.Errorf("test for %s underlying type unsupported", addParentPath(parentpath, field.Name))
 							return
 						}
@@ -574,51 +930,62 @@ func RunTestFlags(somestruct interface{}, opts *TestFieldSubstOpts) (ret []strin
 					}
 				}
 
-				if !fieldValue.IsNil() {
+				// a nil slice is tested as an empty one
+				if !fieldValue.IsNil() || field.Type.Kind() == reflect.Slice {
 					if field.Type.Kind() == reflect.Slice {
 						if op != nil {
-							err = runTest(op, fieldValue, field.Name)
+							var failed *testOp
+							failed, err = runTest(op, fieldValue, field.Name)
 							ret = append(ret, addParentPath(parentpath, field.Name))
 							if err != nil {
-								err = fmt.Errorf("field %s: %s", addParentPath(parentpath, field.Name), err.Error())
-								return
+								if err = testFailed(addParentPath(parentpath, field.Name), testval, failed, fieldValue, confops, err); err != nil {
+									return
+								}
 							}
-						} else {
-							for i := 0; i < fieldValue.Len(); i++ {
-								debugf("test: slice: %s[%d]\n", field.Name, i)
-								switch field.Type.Elem().Kind() {
-								case reflect.Ptr:
-									switch field.Type.Elem().Elem().Kind() {
-									case reflect.Struct:
-										err := innerTest(addParentPath(parentpath, fmt.Sprintf("%s[%d]", field.Name, i)), fieldValue.Index(i).Elem().Addr().Interface())
-										if err != nil {
-											return err
-										}
-									default:
-										debugf("test: unsupported slice of type %s - ignoring (2)\n", field.Type.Elem().Kind().String())
-									}
+						}
+						// the structs in the slice have tests of their own, even if the slice has one
+						for i := 0; i < fieldValue.Len(); i++ {
+							debugf("test: slice: %s[%d]\n", field.Name, i)
+							switch field.Type.Elem().Kind() {
+							case reflect.Ptr:
+								switch field.Type.Elem().Elem().Kind() {
 								case reflect.Struct:
-									err := innerTest(addParentPath(parentpath, fmt.Sprintf("%s[%d]", field.Name, i)), fieldValue.Index(i).Addr().Interface())
+									if !conv.isStructType(field.Type.Elem().Elem()) {
+										continue
+									}
+									err := innerTest(addParentPath(parentpath, fmt.Sprintf("%s[%d]", field.Name, i)), fieldValue.Index(i).Elem().Addr().Interface())
 									if err != nil {
 										return err
 									}
 								default:
-									debugf("test: unsupported slice of type %s - ignoring\n", field.Type.Elem().Kind().String())
+									debugf("test: unsupported slice of type %s - ignoring (2)\n", field.Type.Elem().Kind().String())
+								}
+							case reflect.Struct:
+								if !conv.isStructType(field.Type.Elem()) {
+									continue
+								}
+								err := innerTest(addParentPath(parentpath, fmt.Sprintf("%s[%d]", field.Name, i)), fieldValue.Index(i).Addr().Interface())
+								if err != nil {
+									return err
 								}
+							default:
+								debugf("test: unsupported slice of type %s - ignoring\n", field.Type.Elem().Kind().String())
 							}
 						}
 					} else
 
 					// is this a Ptr to a struct?
-					if t.Elem().Kind() == reflect.Struct {
+					if conv.isStructType(t.Elem()) {
 						// see if there is a test tag for this struct?
 						if op != nil {
 							debugf("test: found test func for this struct ptr!\n")
-							err = runTest(op, fieldValue, field.Name)
+							var failed *testOp
+							failed, err = runTest(op.forPtr(wasNil), fieldValue, field.Name)
 							ret = append(ret, addParentPath(parentpath, field.Name))
 							if err != nil {
-								err = fmt.Errorf("field %s: %s", addParentPath(parentpath, field.Name), err.Error())
-								return err
+								if err = testFailed(addParentPath(parentpath, field.Name), testval, failed, fieldValue, confops, err); err != nil {
+									return
+								}
 							}
 						} else {
 							err := innerTest(addParentPath(parentpath, field.Name), fieldValue.Elem().Addr().Interface())
@@ -633,16 +1000,36 @@ func RunTestFlags(somestruct interface{}, opts *TestFieldSubstOpts) (ret []strin
 								debugf("test: skip zero (test)\n")
 								continue
 							}
-							err = runTest(op, fieldValue.Elem(), field.Name)
+							var failed *testOp
+							failed, err = runTest(op.forPtr(wasNil), fieldValue.Elem(), field.Name)
 							if err != nil {
-								err = fmt.Errorf("field %s: %s", addParentPath(parentpath, field.Name), err.Error())
-								return
+								if err = testFailed(addParentPath(parentpath, field.Name), testval, failed, fieldValue.Elem(), confops, err); err != nil {
+									return
+								}
 							}
 							ret = append(ret, addParentPath(parentpath, field.Name))
 						}
 					}
 				}
-			} else if field.Type.Kind() == reflect.Struct {
+			} else if conv.isMapType(field.Type) {
+				if op != nil {
+					// the map is tested as a whole, i.e. len>=1 or $(func), and its items with keys(), values() or each()
+					var failed *testOp
+					failed, err = runTest(op, fieldValue, field.Name)
+					ret = append(ret, addParentPath(parentpath, field.Name))
+					if err != nil {
+						if err = testFailed(addParentPath(parentpath, field.Name), testval, failed, fieldValue, confops, err); err != nil {
+							return
+						}
+					}
+				}
+				if conv.isMapOfStructs(field.Type) && !fieldValue.IsNil() {
+					err := walkMapStructs(addParentPath(parentpath, field.Name), fieldValue, innerTest)
+					if err != nil {
+						return err
+					}
+				}
+			} else if conv.isStructType(field.Type) {
 				// recurse
 				fieldValue := inputValue.FieldByName(field.Name)
 				// is this a Ptr to a struct?
@@ -650,7 +1037,7 @@ func RunTestFlags(somestruct interface{}, opts *TestFieldSubstOpts) (ret []strin
 				if err != nil {
 					return err
 				}
-			} else if field.Type.Kind() == reflect.Slice {
+			} else if field.Type.Kind() == reflect.Slice && !conv.isConvertedType(field.Type) {
 				// recurse
 				debugf("test: skip slice 2\n")
 
@@ -660,10 +1047,12 @@ func RunTestFlags(somestruct interface{}, opts *TestFieldSubstOpts) (ret []strin
 						debugf("test: skip zero (test) 2\n")
 						continue
 					}
-					err = runTest(op, fieldValue, field.Name)
+					var failed *testOp
+					failed, err = runTest(op, fieldValue, field.Name)
 					if err != nil {
-						err = fmt.Errorf("field %s: %s", addParentPath(parentpath, field.Name), err.Error())
-						return
+						if err = testFailed(addParentPath(parentpath, field.Name), testval, failed, fieldValue, confops, err); err != nil {
+							return
+						}
 					}
 					ret = append(ret, addParentPath(parentpath, field.Name))
 				}
@@ -679,5 +1068,5 @@ func RunTestFlags(somestruct interface{}, opts *TestFieldSubstOpts) (ret []strin
 	}
 
 	err = innerTest("", somestruct)
-	return ret, err
+	return ret, errs.result(err)
 }
@@ -1,6 +1,7 @@
 package conftagz
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -257,3 +258,27 @@ func TestTestSliceIntsFalseCustom(t *testing.T) {
 	assert.True(t, testslicefunc_ran)
 
 }
+
+func TestTestWarn(t *testing.T) {
+	s := struct {
+		Port  int `test:">=1024" conf:"testwarn"`
+		Count int `test:">0"`
+	}{Port: 80, Count: 1}
+	var warnings []string
+	warnf := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	_, err := RunTestFlags(&s, &TestFieldSubstOpts{WarnFunc: warnf})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"warning: field Port: value 80 ! >= 1024\n"}, warnings)
+
+	s.Count = 0
+	warnings = nil
+	_, err = RunTestFlags(&s, &TestFieldSubstOpts{WarnFunc: warnf})
+	assert.EqualError(t, err, "field Count: value 0 ! > 0")
+
+	warnings = nil
+	_, err = RunTestFlags(&s, &TestFieldSubstOpts{WarnFunc: warnf, OnlyWarn: true})
+	assert.Nil(t, err)
+	assert.Len(t, warnings, 2)
+}
@@ -0,0 +1,114 @@
+package conftagz
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// listItems returns the items of a slice, array or map to test with unique or sorted,
+// with the index of each, i.e. [3] or [primary]. If by is set, it is the field of each
+// item which is compared, i.e. Name or Addr.IP.
+func listItems(conv converterMap, val reflect.Value, by string) (indexes []string, items []reflect.Value, err error) {
+	add := func(index string, item reflect.Value) {
+		item = derefOrZero(item)
+		if len(by) > 0 {
+			var ok bool
+			if item, _, ok = conv.lookupFieldPath(item, by); !ok {
+				err = fmt.Errorf("%s is not a field of the items", by)
+			}
+		} else if item.Kind() == reflect.Struct && !conv.isConvertedType(item.Type()) {
+			err = fmt.Errorf("the items are structs, so a field is needed, i.e. unique=Name")
+		}
+		indexes = append(indexes, index)
+		items = append(items, item)
+	}
+	val = derefOrZero(val)
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		for n := 0; n < val.Len() && err == nil; n++ {
+			add(fmt.Sprintf("[%d]", n), val.Index(n))
+		}
+	case reflect.Map:
+		for _, key := range sortedMapKeys(val) {
+			if err != nil {
+				break
+			}
+			add(mapPath("", conv.formatValue(key)), val.MapIndex(key))
+		}
+	default:
+		err = fmt.Errorf("test operator must be on a slice or map field")
+	}
+	return
+}
+
+// runUnique fails for every item which is the same as one before it. by is the field
+// of the items to compare, if they are structs.
+func runUnique(conv converterMap, op *testOp, val reflect.Value) error {
+	indexes, items, err := listItems(conv, val, op.ValString)
+	if err != nil {
+		return err
+	}
+	var dups itemErrors
+	seen := make(map[string]string)
+	for n, item := range items {
+		s := conv.formatValue(item)
+		first, ok := seen[s]
+		if !ok {
+			seen[s] = indexes[n]
+			continue
+		}
+		what := "value"
+		if len(op.ValString) > 0 {
+			what = op.ValString
+		}
+		dups = append(dups, &itemError{index: indexes[n], failed: op, value: item,
+			err: fmt.Errorf("%s %q is the same as %s", what, s, first)})
+	}
+	if len(dups) > 0 {
+		return dups
+	}
+	return nil
+}
+
+// compareValues orders two values of the same type, for sorted
+func compareValues(a reflect.Value, b reflect.Value) (int, error) {
+	if a.Type() == timeType {
+		return a.Interface().(time.Time).Compare(b.Interface().(time.Time)), nil
+	}
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cmp.Compare(a.Uint(), b.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(a.Float(), b.Float()), nil
+	case reflect.String:
+		return strings.Compare(a.String(), b.String()), nil
+	}
+	return 0, fmt.Errorf("sorted needs numbers, strings or times, not %s", a.Type())
+}
+
+// runSorted fails at the first item of a slice which is less than the one before it
+func runSorted(conv converterMap, op *testOp, val reflect.Value) error {
+	if derefOrZero(val).Kind() == reflect.Map {
+		return fmt.Errorf("test operator %s must be on a slice field", op.String())
+	}
+	indexes, items, err := listItems(conv, val, op.ValString)
+	if err != nil {
+		return err
+	}
+	for n := 1; n < len(items); n++ {
+		c, err := compareValues(items[n-1], items[n])
+		if err != nil {
+			return err
+		}
+		if c > 0 {
+			return itemErrors{&itemError{index: indexes[n], failed: op, value: items[n],
+				err: fmt.Errorf("value %s is before %s at %s, not sorted", conv.formatValue(items[n]), conv.formatValue(items[n-1]), indexes[n-1])}}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package conftagz
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type uniqueServer struct {
+	Name string `test:"notblank"`
+	IP   string
+	Port int
+}
+
+func TestTestUnique(t *testing.T) {
+	s := struct {
+		Servers  []*uniqueServer         `test:"unique=Name,unique=IP"`
+		Ports    []int                   `test:"unique,sorted"`
+		Backends map[string]uniqueServer `test:"unique=Port"`
+	}{
+		Servers: []*uniqueServer{
+			{Name: "a", IP: "10.0.0.1"},
+			{Name: "b", IP: "10.0.0.2"},
+			{Name: "a", IP: "10.0.0.3"},
+			{Name: "", IP: "10.0.0.4"},
+		},
+		Ports: []int{80, 443, 443, 22},
+		Backends: map[string]uniqueServer{
+			"one": {Name: "x", Port: 80},
+			"two": {Name: "y", Port: 80},
+		},
+	}
+	_, err := RunTestFlags(&s, &TestFieldSubstOpts{CollectErrors: true})
+	var got []string
+	var fe *FieldError
+	for _, e := range err.(*MultiError).Errors {
+		if assert.ErrorAs(t, e, &fe) {
+			got = append(got, fe.Path+" "+fe.Op+": "+fe.Err.Error())
+		}
+	}
+	assert.Equal(t, []string{
+		`Servers[2] unique=Name: Name "a" is the same as [0]`,
+		`Servers[3].Name notblank: value is blank`,
+		`Ports[2] unique: value "443" is the same as [1]`,
+		`Backends[two] unique=Port: Port "80" is the same as [one]`,
+	}, got)
+
+	s.Servers[2].Name = "c"
+	s.Servers[3] = &uniqueServer{Name: "d", IP: "10.0.0.1"}
+	s.Ports = []int{22, 80, 443}
+	s.Backends = nil
+	_, err = RunTestFlags(&s, nil)
+	assert.EqualError(t, err, `field Servers[3]: IP "10.0.0.1" is the same as [0]`)
+}
+
+func TestTestSorted(t *testing.T) {
+	tests := []struct {
+		tag  string
+		val  interface{}
+		fail string
+	}{
+		{"sorted", []string{"a", "b", "b", "c"}, ""},
+		{"sorted", []string{"a", "c", "b"}, "[2]: value b is before c at [1], not sorted"},
+		{"sorted", []float64{1.5, 0.5}, "[1]: value 0.5 is before 1.5 at [0], not sorted"},
+		{"sortedby=Port", []uniqueServer{{Port: 1}, {Port: 2}}, ""},
+		{"sortedby=Port", []uniqueServer{{Port: 2}, {Port: 1}}, "[1]: value 1 is before 2 at [0], not sorted"},
+		{"sorted", []uniqueServer{{Port: 1}}, "the items are structs, so a field is needed, i.e. unique=Name"},
+		{"sortedby=Nope", []uniqueServer{{Port: 1}}, "Nope is not a field of the items"},
+		{"sorted", map[string]int{"a": 1}, "test operator sorted must be on a slice field"},
+		{"unique", 5, "test operator must be on a slice or map field"},
+	}
+	for _, tt := range tests {
+		op, err := parseTestVal(tt.tag, nil)
+		if !assert.Nil(t, err, tt.tag) {
+			continue
+		}
+		_, err = runTest(op, reflect.ValueOf(tt.val), "V")
+		if len(tt.fail) < 1 {
+			assert.Nil(t, err, tt.tag)
+		} else {
+			assert.EqualError(t, err, tt.fail, tt.tag)
+		}
+	}
+
+	_, err := parseTestVal("unique=", nil)
+	assert.EqualError(t, err, `test: unique needs the name of a field, not ""`)
+}